@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/MikaelSmith/onmyoji-soul-planner/onmyoji"
+	"gopkg.in/yaml.v3"
+)
+
+// maxCachedSearches limits how many searches the cache remembers, dropping the least recent.
+const maxCachedSearches = 50
+
+// cachedSearch remembers the sets an exhaustive search found, and the souls it searched.
+type cachedSearch struct {
+	// Key describes the search, as returned by cacheKey.
+	Key   string
+	Souls [6][]uint64 `yaml:",flow"`
+	N     int
+	Sets  [][6]onmyoji.Soul
+}
+
+// searchCache remembers earlier searches, so that a later run only has to check sets with new
+// souls, along with the souls recommended to each shikigami.
+type searchCache struct {
+	Searches    []cachedSearch
+	Recommended map[string][6]onmyoji.Soul
+}
+
+// loadCache reads the cache, returning an empty cache if the file doesn't exist.
+func loadCache(path string) (*searchCache, error) {
+	cache := &searchCache{Recommended: make(map[string][6]onmyoji.Soul)}
+	source, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(source, cache); err != nil {
+		return nil, fmt.Errorf("error parsing %v: %v", path, err)
+	}
+	if cache.Recommended == nil {
+		cache.Recommended = make(map[string][6]onmyoji.Soul)
+	}
+	return cache, nil
+}
+
+func (c *searchCache) save(path string) error {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0644)
+}
+
+// cacheKey describes everything about a search for a shikigami that changes its results, apart from
+// the souls searched. It includes a fingerprint of the soul type catalog, so changing a -soul-types
+// file means searching again.
+func cacheKey(name string, q onmyoji.Query) string {
+	return fmt.Sprintf("%v %q %q %q %q %v %v %+v types:%x", name, q.Primaries, q.Secondaries, q.Layout, q.MainStats, q.Optimize, q.Constraints, q.Evaluator, onmyoji.SoulTypesFingerprint())
+}
+
+// previous returns the earlier search for the key, with its sets evaluated for the query.
+func (c *searchCache) previous(key string, q onmyoji.Query) onmyoji.Previous {
+	for _, s := range c.Searches {
+		if s.Key != key {
+			continue
+		}
+		prev := onmyoji.Previous{Souls: s.Souls, N: s.N}
+		for _, souls := range s.Sets {
+			prev.Results = append(prev.Results, q.Evaluator.Evaluate(onmyoji.NewSoulSet(souls)))
+		}
+		return prev
+	}
+	return onmyoji.Previous{}
+}
+
+// remember records the sets a search of db found, replacing any earlier search with the same key.
+func (c *searchCache) remember(key string, db onmyoji.SoulDb, n int, results []onmyoji.Result) {
+	s := cachedSearch{Key: key, Souls: db.Fingerprints(), N: n}
+	for _, r := range results {
+		s.Sets = append(s.Sets, r.Souls.Souls())
+	}
+	searches := []cachedSearch{s}
+	for _, old := range c.Searches {
+		if old.Key != key && len(searches) < maxCachedSearches {
+			searches = append(searches, old)
+		}
+	}
+	c.Searches = searches
+}
+
+// recommend records the souls recommended to a shikigami, returning whether there was an earlier
+// recommendation and whether it differed.
+func (c *searchCache) recommend(name string, r onmyoji.Result) (known, changed bool) {
+	souls := r.Souls.Souls()
+	old, known := c.Recommended[name]
+	c.Recommended[name] = souls
+	return known, known && old != souls
+}
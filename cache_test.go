@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/MikaelSmith/onmyoji-soul-planner/onmyoji"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheForgetsSearchesWhenSoulTypesChange(t *testing.T) {
+	shiki, err := onmyoji.GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	q := onmyoji.Query{Optimize: onmyoji.Damage, Evaluator: onmyoji.Evaluator{Shikigami: shiki}}
+	sl := onmyoji.Soul{ID: 1, Type: "Cache Test Soul", Atk: 486}
+	db := onmyoji.SoulDb{Slot1: []onmyoji.Soul{sl}, Slot2: []onmyoji.Soul{sl}, Slot3: []onmyoji.Soul{sl}, Slot4: []onmyoji.Soul{sl}, Slot5: []onmyoji.Soul{sl}, Slot6: []onmyoji.Soul{sl}}
+
+	cache := &searchCache{Recommended: make(map[string][6]onmyoji.Soul)}
+	cache.remember(cacheKey("Onikiri", q), db, 1, db.BestSets(1, q))
+	assert.Equal(t, 1, cache.previous(cacheKey("Onikiri", q), q).N)
+
+	// Changing what a soul type does, as a -soul-types file would, means searching again.
+	effect := onmyoji.SetEffect{Pieces: 4, Kind: onmyoji.DamageEffect, Multiplier: 0.5}
+	assert.NoError(t, onmyoji.DefineSoulTypes([]onmyoji.SoulType{{Name: "Cache Test Soul", Effects: []onmyoji.SetEffect{effect}}}))
+	assert.Equal(t, 0, cache.previous(cacheKey("Onikiri", q), q).N)
+}
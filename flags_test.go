@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/MikaelSmith/onmyoji-soul-planner/onmyoji"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModifyFlags(t *testing.T) {
+	values := map[string]string{
+		"modify-atk": "1", "modify-atkbonus": "2", "modify-crit": "3", "modify-critdmg": "4",
+		"modify-def": "5", "modify-defbonus": "6", "modify-effecthit": "7", "modify-effectres": "8",
+	}
+	for name, value := range values {
+		assert.NoError(t, flag.Set(name, value))
+	}
+	defer func() {
+		for name := range values {
+			assert.NoError(t, flag.Set(name, "0"))
+		}
+	}()
+
+	// The flags add to the modifiers a team member sets.
+	mods := applyCliMods(onmyoji.Modifiers{Def: 10, HPBonus: 20})
+	assert.Equal(t, onmyoji.Modifiers{Atk: 1, AtkBonus: 2, Crit: 3, CritDmg: 4, HPBonus: 20, Def: 15, DefBonus: 6, EffectHit: 7, EffectRes: 8}, mods)
+}
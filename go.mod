@@ -3,7 +3,6 @@ module github.com/MikaelSmith/onmyoji-soul-planner
 go 1.12
 
 require (
-	github.com/benbjohnson/immutable v0.2.0
 	github.com/stretchr/testify v1.4.0
 	gopkg.in/yaml.v3 v3.0.0-20190709130402-674ba3eaed22
 )
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/MikaelSmith/onmyoji-soul-planner/onmyoji"
 	"gopkg.in/yaml.v3"
@@ -15,6 +21,11 @@ import (
 
 type constraint struct {
 	Low, High int
+	// FasterThan and SlowerThan name another team member whose final speed this member must beat,
+	// or stay under, by at least By (default 1). A By of 0 allows the same speed.
+	FasterThan string `yaml:"faster_than"`
+	SlowerThan string `yaml:"slower_than"`
+	By         *int
 }
 
 func parseConstraint(s string) constraint {
@@ -40,6 +51,18 @@ func parseConstraint(s string) constraint {
 	return constraint{Low: consf[0], High: consf[1]}
 }
 
+// slack returns how far v is from the nearest end of the constraint.
+func (c constraint) slack(v int) int {
+	slack := math.MaxInt32
+	if c.Low > 0 && v-c.Low < slack {
+		slack = v - c.Low
+	}
+	if c.High > 0 && c.High-v < slack {
+		slack = c.High - v
+	}
+	return slack
+}
+
 type member struct {
 	onmyoji.Shikigami
 	Name        string
@@ -47,18 +70,54 @@ type member struct {
 	Primaries   []string
 	Secondary   string
 	Secondaries []string
+	Layout      onmyoji.Layout
 	Optimize    onmyoji.Optimizer
 	Constraints map[string]constraint
-	Modifiers   onmyoji.Modifiers
+	// MainStats maps slots, from 1 to 6, to the main stat the soul there must have.
+	MainStats map[int]string `yaml:"main_stats"`
+	Modifiers onmyoji.Modifiers
+	Weight    float64
 }
 
 var soulsSource = flag.String("soulsdb", "souls.yaml", "A YAML file describing your souls")
-var ignoreSetBonus = flag.Bool("ignore-set", false, "Ignore the primary set effect when calculating damage")
+var ignoreSetBonus = flag.Bool("ignore-set", false, "Ignore set effects other than attribute bonuses when calculating damage")
 var atkMod = flag.Int("modify-atk", 0, "Modify attack to account for buffs and/or debuffs")
 var atkBonusMod = flag.Int("modify-atkbonus", 0, "Modify attack bonus to account for buffs and/or debuffs")
 var critMod = flag.Int("modify-crit", 0, "Modify crit to account for buffs and/or debuffs")
 var critDmgMod = flag.Int("modify-critdmg", 0, "Modify crit damage to account for buffs and/or debuffs")
+var defMod = flag.Int("modify-def", 0, "Modify defense to account for buffs and/or debuffs")
+var defBonusMod = flag.Int("modify-defbonus", 0, "Modify defense bonus to account for buffs and/or debuffs")
+var effectHitMod = flag.Int("modify-effecthit", 0, "Modify effect hit to account for buffs and/or debuffs")
+var effectResMod = flag.Int("modify-effectres", 0, "Modify effect resist to account for buffs and/or debuffs")
 var orbs = flag.Int("orbs", 5, "Specify how many orbs to assume when attacking")
+var joint = flag.Bool("joint", false, "Allocate souls to the whole team together instead of one shikigami at a time")
+var optimize = flag.String("optimize", string(onmyoji.Damage), "What to maximize for shikigami that don't set optimize: Damage, HP, Heal or an expression such as \"0.7*dmg + 0.3*hp + 50*spd\"")
+var pareto = flag.String("pareto", "", "Show the soul sets for a single shikigami that no other set beats on every one of these comma-separated attributes, such as dmg,spd")
+var timeout = flag.Duration("timeout", 0, "Stop searching after this long, such as 10m, and show the best souls found so far")
+var top = flag.Int("top", 1, "Show the N best soul sets for each shikigami; the best one is still used when planning the rest of the team")
+var workers = flag.Int("workers", 0, "How many soul combinations to search at once; 0 uses one per CPU")
+var showStats = flag.Bool("stats", false, "Show how many soul sets each search checked, how many each constraint rejected and how long it took")
+var strategy = flag.String("strategy", string(onmyoji.Exhaustive), "How to search: exhaustive checks every soul combination, while anneal, genetic and beam are much faster for large soul collections but might miss the best sets")
+var seed = flag.Int64("seed", 1, "Seed for the random choices of the anneal and genetic strategies; the same seed finds the same souls")
+var cachePath = flag.String("cache", "", "A file to remember searches in, so later runs only check soul sets that include new souls and say which shikigami's souls changed")
+var keep = flag.Float64("keep", 0, "Keep the souls a shikigami already wears unless the best set found is more than this many percent better; 0 always picks the best set")
+var soulTypesSource = flag.String("soul-types", "", "A YAML file of soul types and set effects to add to, or change in, the built-in catalog")
+var project = flag.Bool("project", true, "Plan with the stats that souls below +15 are expected to have once enhanced, for souls that record their stars, level and main stat")
+var estimate = flag.Bool("estimate", false, "Show how many soul combinations each shikigami's search could check and how long that could take, without searching")
+
+// isLayout returns whether a command-line argument is a layout, such as 4xSeductress+2x* or free,
+// rather than a list of soul types.
+func isLayout(arg string) bool {
+	return strings.EqualFold(arg, string(onmyoji.Free)) || (arg != "" && arg[0] >= '0' && arg[0] <= '9')
+}
+
+// souls describes the soul types a member asks for.
+func (m member) souls() string {
+	if m.Layout != "" {
+		return string(m.Layout)
+	}
+	return strings.Join(m.Primaries, ", ")
+}
 
 func splitSouls(arg string) []string {
 	if len(arg) == 0 {
@@ -70,15 +129,36 @@ func splitSouls(arg string) []string {
 func main() {
 	flag.Usage = func() {
 		fmt.Println(`Usage: onmyoji-soul-planner [options] <team.yaml> OR
-       onmyoji-soul-planner [options] <shikigami> <main soul> [<secondary soul>] [<attr>=<constraint>]`)
+       onmyoji-soul-planner [options] <shikigami> <main soul> [<secondary soul>] [<attr>=<constraint>] OR
+       onmyoji-soul-planner [options] <shikigami> <layout> [<attr>=<constraint>]
+Put advise-upgrades before the team or shikigami to rank which souls are worth enhancing next.`)
 		flag.PrintDefaults()
 	}
 
 	log.SetPrefix("")
 	log.SetFlags(0)
 	flag.Parse()
+	if *workers < 0 {
+		log.Fatal("-workers must not be negative")
+	}
+	if *keep < 0 {
+		log.Fatal("-keep must not be negative")
+	}
+	if err := onmyoji.Strategy(*strategy).Validate(); err != nil {
+		log.Fatalf("Error with -strategy: %v", err)
+	}
+
+	if *soulTypesSource != "" {
+		if err := readSoulTypes(*soulTypesSource); err != nil {
+			log.Fatalf("Error reading %v: %v", *soulTypesSource, err)
+		}
+	}
 
 	args := flag.Args()
+	advise := len(args) > 0 && args[0] == "advise-upgrades"
+	if advise {
+		args = args[1:]
+	}
 	if len(args) == 0 {
 		flag.Usage()
 		os.Exit(0)
@@ -89,23 +169,26 @@ func main() {
 		name, mainSoul, secondarySoul := args[0], args[1], ""
 
 		rem := args[2:]
-		if len(rem) > 0 && !strings.Contains(rem[0], "=") {
+		var layout onmyoji.Layout
+		if isLayout(mainSoul) {
+			layout, mainSoul = onmyoji.Layout(mainSoul), ""
+		} else if len(rem) > 0 && !strings.Contains(rem[0], "=") {
 			secondarySoul = rem[0]
 			rem = rem[1:]
 		}
 
 		constraints := make(map[string]constraint)
-		allowed := map[string]struct{}{"crit": {}, "spd": {}}
+		mainStats := make(map[int]string)
 		for _, arg := range rem {
 			pair := strings.Split(arg, "=")
 			if len(pair) != 2 {
-				log.Fatalf("Unknown argument %v, must be of the form <attribute>=<range>, such as spd=117-127 or crit=1.0", arg)
+				log.Fatalf("Unknown argument %v, must be of the form <attribute>=<range>, such as spd=117-127 or hp=12000-, or slot<N>=<main stat>, such as slot2=spd", arg)
 			}
 			key := strings.ToLower(pair[0])
-			if _, ok := allowed[key]; !ok {
-				log.Fatalf("Unsupported attribute constraint %v", key)
+			if slot, err := strconv.Atoi(strings.TrimPrefix(key, "slot")); err == nil && strings.HasPrefix(key, "slot") {
+				mainStats[slot] = strings.ToLower(pair[1])
+				continue
 			}
-
 			constraints[key] = parseConstraint(pair[1])
 		}
 
@@ -113,7 +196,9 @@ func main() {
 			Name:        name,
 			Primaries:   splitSouls(mainSoul),
 			Secondaries: splitSouls(secondarySoul),
+			Layout:      layout,
 			Constraints: constraints,
+			MainStats:   mainStats,
 		})
 	} else {
 		source, err := ioutil.ReadFile(args[0])
@@ -121,7 +206,9 @@ func main() {
 			log.Fatalf("Error reading %v: %v", args[0], err)
 		}
 
-		if err := yaml.Unmarshal(source, &team); err != nil {
+		decoder := yaml.NewDecoder(bytes.NewReader(source))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&team); err != nil {
 			log.Fatalf("Error parsing %v: %v", args[0], err)
 		}
 	}
@@ -159,65 +246,652 @@ func main() {
 			}
 		}
 
+		if place.Layout != "" {
+			if len(place.Primaries) > 0 || len(place.Secondaries) > 0 {
+				log.Fatalf("Shiki %v: only set one of layout or primary and secondary souls", place.Name)
+			}
+			if err := place.Layout.Validate(); err != nil {
+				log.Fatalf("Shiki %v: %v", place.Name, err)
+			}
+		}
+
+		for slot, stat := range place.MainStats {
+			if err := onmyoji.CheckMainStat(slot, stat); err != nil {
+				log.Fatalf("Shiki %v: %v", place.Name, err)
+			}
+		}
+
 		if place.Optimize == "" {
-			place.Optimize = onmyoji.Damage
+			place.Optimize = onmyoji.Optimizer(*optimize)
+		}
+		if err := place.Optimize.Validate(); err != nil {
+			log.Fatalf("Shiki %v: %v", place.Name, err)
+		}
+
+		for key, cons := range place.Constraints {
+			if err := (onmyoji.Constraint{Attribute: key}).Validate(); err != nil {
+				log.Fatalf("Shiki %v: unsupported constraint: %v", place.Name, err)
+			}
+			for _, other := range []string{cons.FasterThan, cons.SlowerThan} {
+				if other == "" {
+					continue
+				}
+				if key != "spd" && key != "speed" {
+					log.Fatalf("Shiki %v: faster_than and slower_than only apply to spd", place.Name)
+				}
+				if j := memberIndex(team, other); j < 0 || j == i {
+					log.Fatalf("Shiki %v: %v must name another member of the team", place.Name, other)
+				}
+			}
+			if cons.By != nil && *cons.By < 0 {
+				log.Fatalf("Shiki %v: by must not be negative", place.Name)
+			}
+		}
+
+		if place.Weight == 0 {
+			place.Weight = 1
 		}
 
 		// Update the team member.
 		team[i] = place
 	}
 
-	source, err := ioutil.ReadFile(*soulsSource)
+	soulsDb, assigned, err := readSouls(*soulsSource)
 	if err != nil {
 		log.Fatalf("Error reading %v: %v", *soulsSource, err)
 	}
+	if assigned > 0 {
+		fmt.Printf("Gave IDs to %v souls in %v\n", assigned, *soulsSource)
+	}
+	if *project && !advise {
+		soulsDb = soulsDb.Projected()
+	}
+
+	if *estimate {
+		estimateTeam(team, soulsDb)
+		return
+	}
 
-	var soulsDb onmyoji.SoulDb
-	if err := yaml.Unmarshal(source, &soulsDb); err != nil {
-		log.Fatalf("Error parsing %v: %v", *soulsSource, err)
+	var cache *searchCache
+	if *cachePath != "" {
+		if cache, err = loadCache(*cachePath); err != nil {
+			log.Fatalf("Error reading %v: %v", *cachePath, err)
+		}
+	}
+
+	ctx, cancel := searchContext()
+	defer cancel()
+
+	if advise {
+		adviseUpgrades(ctx, team, soulsDb)
+		return
+	}
+
+	if *pareto != "" {
+		paretoFront(ctx, team, soulsDb)
+		return
+	}
+
+	if *joint {
+		if *keep > 0 {
+			log.Fatal("-keep only works when optimizing one shikigami at a time")
+		}
+		planTeam(ctx, team, soulsDb, cache)
+		return
 	}
 
 	// After optimizing each member, remove those souls from the db.
+	before := soulsDb.Clone()
+	members := teamMembers(team)
+	planned := make([]onmyoji.Result, len(team))
+	var changed recommendations
+	for i, place := range team {
+		fmt.Printf("Finding best souls for %v with %v\n", place.Name, place.souls())
+		q := onmyoji.Constrain(members, i, planned)
+		key := cacheKey(place.Name, q)
+		var prev onmyoji.Previous
+		if cache != nil {
+			prev = cache.previous(key, q)
+		}
+		usable := soulsDb.For(place.Name)
+		results, stats, err := usable.BestSetsSinceContext(ctx, *top, q, prev)
+		if stats.Reused && stats.NewSouls == 0 {
+			fmt.Println("No new souls since the last run, so reusing its search")
+		} else if stats.Reused {
+			fmt.Printf("Reusing the last run's search, and only checking soul sets with the %v new souls\n", stats.NewSouls)
+		}
+		reportPruned(stats, usable)
+		if *showStats {
+			reportStats(stats, q)
+		}
+		if err != nil {
+			reportStopped(err, stats)
+		}
+
+		if len(results) == 0 {
+			if err != nil {
+				log.Fatal("No souls that satisfy constraints were found before the search stopped")
+			}
+			log.Fatal("Unable to find souls of the requested types that satisfy constraints")
+		}
+		reportGap(q, stats, results[0])
+		if cache != nil && err == nil && !heuristic() {
+			// Heuristic searches might miss sets, so a later search can't rely on them.
+			cache.remember(key, usable, *top, results)
+		}
+		if current, ok := keepLoadout(usable, place.Name, q, results[0]); ok {
+			results = []onmyoji.Result{current}
+		}
+
+		for i, result := range results {
+			if len(results) > 1 {
+				fmt.Printf("#%v: ", i+1)
+			}
+			printResult(place, result, soulsDb)
+		}
+		planned[i] = results[0]
+		if cache != nil && err == nil {
+			changed.add(cache, place.Name, results[0])
+		}
+		if err := soulsDb.Remove(results[0].Souls); err != nil {
+			log.Fatalf("Error removing souls for %v: %v", place.Name, err)
+		}
+
+		if err != nil {
+			if i+1 < len(team) {
+				fmt.Printf("Skipped the rest of the team after stopping\n")
+			}
+			changed.report()
+			saveCache(cache)
+			return
+		}
+	}
+	if len(team) > 1 {
+		printTurnOrder(team, planned)
+	}
+	printSwaps(before, team, planned)
+	changed.report()
+	saveCache(cache)
+}
+
+// readSoulTypes adds the soul types in a file to the catalog.
+func readSoulTypes(path string) error {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var types []onmyoji.SoulType
+	decoder := yaml.NewDecoder(bytes.NewReader(source))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&types); err != nil {
+		return err
+	}
+	return onmyoji.DefineSoulTypes(types)
+}
+
+// printSwaps lists the moves that give each team member its planned souls, starting from the
+// loadouts in the souls database, if it has any.
+func printSwaps(soulsDb onmyoji.SoulDb, team []member, results []onmyoji.Result) {
+	if len(soulsDb.Loadouts) == 0 {
+		return
+	}
+	names := make([]string, len(team))
+	sets := make([]onmyoji.SoulSet, len(team))
+	for i, place := range team {
+		names[i], sets[i] = place.Name, results[i].Souls
+	}
+	swaps := soulsDb.Swaps(names, sets)
+	if len(swaps.Moves) == 0 && len(swaps.Conflicts) == 0 {
+		fmt.Println("Every shikigami already wears its souls")
+		return
+	}
+	fmt.Printf("To equip these souls in %v moves:\n", len(swaps.Moves))
+	for i, move := range swaps.Moves {
+		fmt.Printf("%v. %v\n", i+1, move)
+	}
+	for _, c := range swaps.Conflicts {
+		fmt.Printf("Conflict: %v\n", c)
+	}
+}
+
+// keepLoadout returns the souls that a shikigami already wears when the -keep flag is set, the
+// souls are all usable and allowed by the query, and the best set isn't more than -keep percent
+// better. Souls worth zero are only kept if no set is better, as no percentage of zero is.
+func keepLoadout(db onmyoji.SoulDb, name string, q onmyoji.Query, best onmyoji.Result) (onmyoji.Result, bool) {
+	if *keep <= 0 {
+		return onmyoji.Result{}, false
+	}
+	set, complete := db.Loadout(name)
+	if !complete || set.IDs() == best.Souls.IDs() {
+		return onmyoji.Result{}, false
+	}
+	current := q.Evaluator.Evaluate(set)
+	if !q.Allows(current) {
+		fmt.Printf("The souls %v already wears don't meet its requirements\n", name)
+		return onmyoji.Result{}, false
+	}
+	value, bestValue := q.Optimize.Value(current), q.Optimize.Value(best)
+	if value == 0 && bestValue > value {
+		fmt.Printf("The best set has %v = %.0f, better than the 0 of the souls %v already wears\n", q.Optimize, bestValue, name)
+		return onmyoji.Result{}, false
+	}
+	var better float64
+	if value != 0 {
+		better = 100 * (bestValue - value) / math.Abs(value)
+	}
+	if better > *keep {
+		fmt.Printf("The best set is %.1f%% better than the souls %v already wears\n", better, name)
+		return onmyoji.Result{}, false
+	}
+	fmt.Printf("Keeping the souls %v already wears, as the best set is only %.1f%% better with %v = %.0f\n", name, better, q.Optimize, bestValue)
+	return current, true
+}
+
+// recommendations tracks which shikigami were recommended different souls than in the last run.
+type recommendations struct {
+	known   bool
+	changed []string
+}
+
+func (c *recommendations) add(cache *searchCache, name string, r onmyoji.Result) {
+	known, changed := cache.recommend(name, r)
+	c.known = c.known || known
+	if changed {
+		c.changed = append(c.changed, name)
+	}
+}
+
+// report prints the shikigami whose souls changed, if there was an earlier run to compare to.
+func (c *recommendations) report() {
+	if len(c.changed) > 0 {
+		fmt.Printf("Souls changed since the last run for %v\n", strings.Join(c.changed, ", "))
+	} else if c.known {
+		fmt.Println("No souls changed since the last run")
+	}
+}
+
+func saveCache(cache *searchCache) {
+	if cache == nil {
+		return
+	}
+	if err := cache.save(*cachePath); err != nil {
+		log.Fatalf("Error writing %v: %v", *cachePath, err)
+	}
+}
+
+// searchContext returns a context that is cancelled by the -timeout flag or the first interrupt, so
+// that searches stop and show what they have found so far. A second interrupt exits immediately.
+func searchContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if *timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), *timeout)
+	}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupts:
+			signal.Stop(interrupts)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// reportPruned prints how many souls in each slot the search left out because other souls of the
+// same type were at least as good.
+func reportPruned(stats onmyoji.Stats, soulsDb onmyoji.SoulDb) {
+	total, pruned := 0, 0
+	bySlot := make([]string, len(stats.Pruned))
+	for k, slot := range [...][]onmyoji.Soul{soulsDb.Slot1, soulsDb.Slot2, soulsDb.Slot3, soulsDb.Slot4, soulsDb.Slot5, soulsDb.Slot6} {
+		total += len(slot)
+		pruned += stats.Pruned[k]
+		bySlot[k] = strconv.Itoa(stats.Pruned[k])
+	}
+	fmt.Printf("Skipped %v of %v souls that other souls of the same type beat (by slot: %v)\n", pruned, total, strings.Join(bySlot, ", "))
+}
+
+// reportStats prints how much work a search for the query did.
+func reportStats(stats onmyoji.Stats, q onmyoji.Query) {
+	fmt.Printf("Checked %v soul sets, evaluated %v and skipped %v partial sets in %v\n",
+		stats.Completed, stats.Evaluated, stats.Skipped, stats.Elapsed.Round(time.Millisecond))
+	for i, c := range q.Constraints {
+		fmt.Printf("  %v rejected %v sets\n", c, stats.Rejected[i])
+	}
+	if q.Accept != nil {
+		fmt.Printf("  other requirements rejected %v sets\n", stats.RejectedByAccept)
+	}
+}
+
+// estimateTeam prints how much work searching for each member's souls could take. Later members
+// are estimated with every soul, as the souls of earlier members aren't known yet.
+func estimateTeam(team []member, soulsDb onmyoji.SoulDb) {
 	for _, place := range team {
-		fmt.Printf("Finding best souls for %v with %v\n", place.Name, strings.Join(place.Primaries, ", "))
-		best := bestSouls(place, soulsDb)
+		fmt.Printf("Estimating the search for %v with %v\n", place.Name, place.souls())
+		usable := soulsDb.For(place.Name)
+		est, err := usable.Estimate(*top, teamMember(place).Query)
+		if err != nil {
+			log.Fatalf("Shiki %v: %v", place.Name, err)
+		}
+		reportPruned(onmyoji.Stats{Pruned: est.Pruned}, usable)
+		fmt.Printf("Up to %.0f soul sets match the soul types, taking up to %v to check them all\n",
+			est.Combinations, est.Time.Round(time.Millisecond))
+	}
+}
+
+// reportStopped explains why a search ended early and how much of it was done.
+func reportStopped(err error, stats onmyoji.Stats) {
+	reason := "interrupted"
+	if err == context.DeadlineExceeded {
+		reason = "timed out"
+	}
+	done := "covering %.1f%% of soul combinations"
+	if heuristic() {
+		done = "%.1f%% of its evaluations"
+	}
+	fmt.Printf("Search %v after "+done+"; showing the best found so far\n", reason, 100*stats.Covered)
+}
+
+// heuristic returns whether the -strategy flag picks a search that might miss the best sets.
+func heuristic() bool {
+	s := onmyoji.Strategy(*strategy)
+	return s != "" && s != onmyoji.Exhaustive
+}
+
+// reportGap prints how far a set found by a heuristic search could be from the best set. The bound
+// is loose, so the best set may be much closer than the gap printed.
+func reportGap(q onmyoji.Query, stats onmyoji.Stats, r onmyoji.Result) {
+	if !heuristic() {
+		return
+	}
+	value := q.Optimize.Value(r)
+	switch {
+	case value >= stats.Bound:
+		fmt.Printf("No set can have %v above %.0f, so the %.0f found is the best\n", q.Optimize, stats.Bound, value)
+	case stats.Bound == 0:
+		fmt.Printf("No set can have %v above 0, so the %.0f found is within %.0f of the upper bound\n", q.Optimize, value, -value)
+	default:
+		gap := 100 * (stats.Bound - value) / math.Abs(stats.Bound)
+		fmt.Printf("No set can have %v above %.0f, so the %.0f found is within %.1f%% of the upper bound %.0f\n", q.Optimize, stats.Bound, value, gap, stats.Bound)
+	}
+}
+
+// planTeam allocates souls to all team members together and compares the result to optimizing
+// each member in turn.
+func planTeam(ctx context.Context, team []member, soulsDb onmyoji.SoulDb, cache *searchCache) {
+	members := teamMembers(team)
+
+	fmt.Println("Finding best souls for the whole team")
+	plan, err := soulsDb.BestTeamContext(ctx, members)
+	if err != nil && plan.Results == nil {
+		if ctx.Err() != nil {
+			log.Fatal("Search stopped before soul sets were found for every team member")
+		}
+		log.Fatalf("Unable to plan team: %v", err)
+	}
+	stopped := err != nil
+	if stopped {
+		fmt.Println("Search stopped early; showing the best plan from the soul sets compared so far")
+	}
+
+	for i, place := range team {
+		fmt.Printf("%v with %v\n", place.Name, place.souls())
+		if *showStats {
+			reportStats(plan.Stats[i], members[i].Query)
+		}
+		reportGap(members[i].Query, plan.Stats[i], plan.Results[i])
+		printResult(place, plan.Results[i], soulsDb)
+	}
+	if len(team) > 1 {
+		printTurnOrder(team, plan.Results)
+	}
+	if !stopped {
+		printSwaps(soulsDb, team, plan.Results)
+	}
+
+	fmt.Printf("Team score: %.0f", plan.Score)
+	if stopped {
+		fmt.Println()
+	} else if greedy, err := soulsDb.GreedyTeamContext(ctx, members); ctx.Err() != nil {
+		fmt.Println(" (stopped before comparing with optimizing one shikigami at a time)")
+	} else if err != nil {
+		fmt.Printf(" (optimizing one shikigami at a time fails: %v)\n", err)
+	} else {
+		if greedy.Score == 0 {
+			fmt.Printf(" (%+.0f over 0 from optimizing one shikigami at a time)\n", plan.Score)
+		} else {
+			fmt.Printf(" (%+.1f%% over %.0f from optimizing one shikigami at a time)\n", 100*(plan.Score-greedy.Score)/math.Abs(greedy.Score), greedy.Score)
+		}
+	}
+	if !plan.Optimal && !stopped {
+		if heuristic() {
+			fmt.Println("The search strategy might miss soul sets, so a better plan might exist")
+		} else {
+			fmt.Println("Too many soul sets had to be compared, so a better plan might exist")
+		}
+	}
+
+	if cache != nil && !stopped {
+		var changed recommendations
+		for i, place := range team {
+			changed.add(cache, place.Name, plan.Results[i])
+		}
+		changed.report()
+		saveCache(cache)
+	}
+}
+
+// paretoFront prints the soul sets for a single shikigami that no other set beats on every axis
+// listed by the -pareto flag.
+func paretoFront(ctx context.Context, team []member, soulsDb onmyoji.SoulDb) {
+	if len(team) != 1 || *joint {
+		log.Fatal("-pareto only works with a single shikigami")
+	}
+	if heuristic() {
+		log.Fatal("-pareto always checks every soul combination, so it only works with -strategy exhaustive")
+	}
+	var axes []onmyoji.Optimizer
+	for _, axis := range strings.Split(*pareto, ",") {
+		axis := onmyoji.Optimizer(strings.TrimSpace(axis))
+		if err := axis.Validate(); err != nil {
+			log.Fatalf("Error with -pareto: %v", err)
+		}
+		axes = append(axes, axis)
+	}
+	if len(axes) < 2 {
+		log.Fatal("-pareto needs at least two comma-separated attributes, such as dmg,spd")
+	}
 
-		if best.Souls.Empty() {
-			log.Fatal("Unable to find souls that include 4 of the primary soul and satisfy constraints")
-			break
+	place := team[0]
+	fmt.Printf("Finding souls for %v with %v that trade off %v\n", place.Name, place.souls(), *pareto)
+	q := teamMember(place).Query
+	usable := soulsDb.For(place.Name)
+	results, stats, err := usable.ParetoFrontContext(ctx, axes, q)
+	reportPruned(stats, usable)
+	if *showStats {
+		reportStats(stats, q)
+	}
+	if err != nil {
+		reportStopped(err, stats)
+	}
+	if len(results) == 0 {
+		log.Fatal("Unable to find souls of the requested types that satisfy constraints")
+	}
+
+	for i, result := range results {
+		values := make([]string, len(axes))
+		for j, axis := range axes {
+			values[j] = fmt.Sprintf("%v = %v", axis, strconv.FormatFloat(axis.Value(result), 'f', -1, 64))
 		}
+		fmt.Printf("#%v: %v\n", i+1, strings.Join(values, ", "))
+		fmt.Println(describe(soulsDb, q.Evaluator, result))
+	}
+}
+
+// adviseUpgrades prints the unfinished souls that are expected to improve a team member's best
+// souls the most once enhanced, best first.
+func adviseUpgrades(ctx context.Context, team []member, soulsDb onmyoji.SoulDb) {
+	fmt.Println("Finding how much enhancing each unfinished soul would improve each shikigami's best souls")
+	upgrades, err := soulsDb.AdviseUpgradesContext(ctx, teamMembers(team))
+	if err != nil {
+		fmt.Println("Search stopped early; showing the souls checked so far")
+	}
+	if len(upgrades) == 0 {
+		if err == nil {
+			fmt.Println("No souls to enhance: souls need their stars, a level below 15 and a main stat to be considered")
+		}
+		return
+	}
+
+	useless := 0
+	for i, up := range upgrades {
+		if up.Member < 0 {
+			useless++
+			continue
+		}
+		place := team[up.Member]
+		fmt.Printf("%v. Slot %v: %v\n", i+1, up.Slot, up.Soul)
+		before, after := place.Optimize.Value(up.Before), place.Optimize.Value(up.After)
+		if before == 0 {
+			fmt.Printf("   %+.0f %v for %v (%.0f to %.0f)\n", up.Gain, place.Optimize, place.Name, before, after)
+		} else {
+			fmt.Printf("   %+.1f%% %v for %v (%.0f to %.0f)\n", up.Percent, place.Optimize, place.Name, before, after)
+		}
+	}
+	if useless > 0 {
+		fmt.Printf("Enhancing the other %v unfinished souls wouldn't improve any shikigami's best souls\n", useless)
+	}
+}
+
+// printResult prints a result, along with its score when optimizing an expression and which of its
+// souls are already equipped.
+func printResult(m member, r onmyoji.Result, soulsDb onmyoji.SoulDb) {
+	switch m.Optimize {
+	case onmyoji.Damage, onmyoji.HP, onmyoji.Heal:
+	default:
+		fmt.Printf("score = %.1f (%v)\n", m.Optimize.Value(r), m.Optimize)
+	}
+	fmt.Println(describe(soulsDb, teamMember(m).Evaluator, r))
+}
 
-		fmt.Println(best)
-		soulsDb.Remove(best.Souls)
+// describe formats a result, noting which of its souls are already equipped or still need
+// enhancing. If any do, it adds how the shikigami would do with the souls as they are now.
+func describe(soulsDb onmyoji.SoulDb, ev onmyoji.Evaluator, r onmyoji.Result) string {
+	desc := soulsDb.Describe(r)
+	if now, projected := soulsDb.Current(r.Souls); projected {
+		desc += "Before enhancing: " + ev.Evaluate(now).Summary() + "\n"
 	}
+	return desc
 }
 
-func bestSouls(m member, soulsDb onmyoji.SoulDb) onmyoji.Result {
-	return soulsDb.BestSet(m.Primaries, m.Secondaries, m.Optimize, func(souls onmyoji.SoulSet) onmyoji.Result {
-		spd := m.Spd
-		for _, sl := range souls.Souls() {
-			spd += sl.Spd
+func memberIndex(team []member, name string) int {
+	for i, place := range team {
+		if strings.EqualFold(place.Name, name) {
+			return i
 		}
-		if cons, ok := m.Constraints["spd"]; ok {
-			if (cons.Low > 0 && spd < cons.Low) || (cons.High > 0 && spd > cons.High) {
-				return onmyoji.Result{}
+	}
+	return -1
+}
+
+// teamMembers converts the team, including the speed order between its members.
+func teamMembers(team []member) []onmyoji.Member {
+	members := make([]onmyoji.Member, len(team))
+	for i, place := range team {
+		members[i] = teamMember(place)
+	}
+	for i, place := range team {
+		for _, cons := range place.Constraints {
+			by := 1
+			if cons.By != nil {
+				by = *cons.By
+			}
+			if cons.FasterThan != "" {
+				members[i].FasterThan = append(members[i].FasterThan, onmyoji.SpeedGap{Member: memberIndex(team, cons.FasterThan), Min: by})
+			}
+			if cons.SlowerThan != "" {
+				j := memberIndex(team, cons.SlowerThan)
+				members[j].FasterThan = append(members[j].FasterThan, onmyoji.SpeedGap{Member: i, Min: by})
 			}
 		}
+	}
+	return members
+}
 
-		opts := onmyoji.DamageOptions{IgnoreSetBonus: *ignoreSetBonus, Orbs: *orbs}
-		mods := applyCliMods(m.Modifiers)
-		crit := souls.ComputeCrit(m.Shikigami, mods.Crit)
-		if cons, ok := m.Constraints["crit"]; ok {
-			if (cons.Low > 0 && crit < cons.Low) || (cons.High > 0 && crit > cons.High) {
-				return onmyoji.Result{}
+// printTurnOrder lists the team from fastest to slowest.
+func printTurnOrder(team []member, results []onmyoji.Result) {
+	order := make([]int, len(team))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return results[order[a]].Spd > results[order[b]].Spd })
+
+	var turns strings.Builder
+	for i, j := range order {
+		if i > 0 {
+			if results[j].Spd == results[order[i-1]].Spd {
+				// The game picks randomly between shikigami with the same speed.
+				turns.WriteString(" = ")
+			} else {
+				turns.WriteString(" > ")
 			}
 		}
+		fmt.Fprintf(&turns, "%v (%v)", team[j].Name, results[j].Spd)
+	}
+	fmt.Printf("Turn order: %v\n", turns.String())
+}
+
+func teamMember(m member) onmyoji.Member {
+	ev := onmyoji.Evaluator{
+		Shikigami: m.Shikigami,
+		Modifiers: applyCliMods(m.Modifiers),
+		Options:   onmyoji.DamageOptions{IgnoreSetBonus: *ignoreSetBonus, Orbs: *orbs},
+	}
+	// Keep the constraints in a fixed order, so statistics about them are reported the same way
+	// every run.
+	keys := make([]string, 0, len(m.Constraints))
+	for key := range m.Constraints {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var constraints []onmyoji.Constraint
+	var tieBreak onmyoji.Comparator
+	for _, key := range keys {
+		cons := m.Constraints[key]
+		if cons.Low == 0 && cons.High == 0 {
+			// Only a speed order, which teamMembers adds.
+			continue
+		}
+		constraints = append(constraints, onmyoji.Constraint{Attribute: key, Min: cons.Low, Max: cons.High})
 
-		hp := souls.HP(m.Shikigami, mods)
-		dmg := souls.Damage(m.Shikigami, mods, opts)
-		heal := souls.Heal(m.Shikigami, mods)
-		return onmyoji.Result{HP: hp, Heal: heal, Damage: dmg, Crit: crit, Spd: spd, Souls: souls}
-	})
+		if key == "spd" || key == "speed" {
+			// Prefer sets that stay furthest inside the speed range, so small speed buffs don't break it.
+			tieBreak = onmyoji.Higher(func(r onmyoji.Result) int { return cons.slack(r.Spd) })
+		}
+	}
+	var mainStats [6]string
+	for slot, stat := range m.MainStats {
+		mainStats[slot-1] = strings.ToLower(stat)
+	}
+	return onmyoji.Member{
+		Name: m.Name,
+		Query: onmyoji.Query{
+			Primaries:   m.Primaries,
+			Secondaries: m.Secondaries,
+			Layout:      m.Layout,
+			MainStats:   mainStats,
+			Optimize:    m.Optimize,
+			Evaluator:   ev,
+			Constraints: constraints,
+			TieBreak:    tieBreak,
+			Workers:     *workers,
+			Strategy:    onmyoji.Strategy(*strategy),
+			Seed:        *seed,
+		},
+		Weight: m.Weight,
+	}
 }
 
 func applyCliMods(mods onmyoji.Modifiers) onmyoji.Modifiers {
@@ -225,5 +899,9 @@ func applyCliMods(mods onmyoji.Modifiers) onmyoji.Modifiers {
 	mods.AtkBonus += *atkBonusMod
 	mods.Crit += *critMod
 	mods.CritDmg += *critDmgMod
+	mods.Def += *defMod
+	mods.DefBonus += *defBonusMod
+	mods.EffectHit += *effectHitMod
+	mods.EffectRes += *effectResMod
 	return mods
 }
@@ -0,0 +1,94 @@
+package onmyoji
+
+import "strings"
+
+// Comparator orders results. It returns a positive number if a is better than b, a negative number
+// if a is worse than b, and 0 if neither is better.
+type Comparator func(a, b Result) int
+
+// Then returns a comparator that uses next to order results that c finds equally good.
+func (c Comparator) Then(next Comparator) Comparator {
+	if c == nil {
+		return next
+	}
+	if next == nil {
+		return c
+	}
+	return func(a, b Result) int {
+		if cmp := c(a, b); cmp != 0 {
+			return cmp
+		}
+		return next(a, b)
+	}
+}
+
+// Higher returns a comparator that prefers results with a higher value.
+func Higher(value func(Result) int) Comparator {
+	return func(a, b Result) int {
+		return compareInts(value(a), value(b))
+	}
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a > b:
+		return 1
+	case a < b:
+		return -1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a > b:
+		return 1
+	case a < b:
+		return -1
+	}
+	return 0
+}
+
+// Comparator orders results by the value the optimizer maximizes.
+func (o Optimizer) Comparator() Comparator {
+	obj := o.objective()
+	return func(a, b Result) int {
+		return compareFloats(obj.value(a), obj.value(b))
+	}
+}
+
+// defaultTieBreak orders results that are otherwise equally good by their other attributes, and
+// finally by their souls, so that the same result is picked every run.
+var defaultTieBreak = Higher(func(r Result) int { return r.HP }).
+	Then(Higher(func(r Result) int { return r.Damage })).
+	Then(Higher(func(r Result) int { return r.Heal })).
+	Then(Higher(func(r Result) int { return r.Spd })).
+	Then(Higher(func(r Result) int { return r.Crit })).
+	Then(bySouls)
+
+// bySouls gives an arbitrary but consistent order to results with different souls.
+func bySouls(a, b Result) int {
+	for k := range a.Souls.souls {
+		if cmp := compareSouls(a.Souls.souls[k], b.Souls.souls[k]); cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+func compareSouls(a, b Soul) int {
+	if cmp := strings.Compare(b.Type, a.Type); cmp != 0 {
+		return cmp
+	}
+	for _, pair := range [...][2]int{
+		{a.Atk, b.Atk}, {a.AtkBonus, b.AtkBonus}, {a.Crit, b.Crit}, {a.CritDmg, b.CritDmg},
+		{a.Spd, b.Spd}, {a.HP, b.HP}, {a.HPBonus, b.HPBonus}, {a.Def, b.Def}, {a.DefBonus, b.DefBonus},
+		{a.EffectHit, b.EffectHit}, {a.EffectRes, b.EffectRes},
+	} {
+		if cmp := compareInts(pair[0], pair[1]); cmp != 0 {
+			return cmp
+		}
+	}
+	// Prefer the soul with the lower ID among identical souls.
+	return compareInts(b.ID, a.ID)
+}
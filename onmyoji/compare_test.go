@@ -0,0 +1,51 @@
+package onmyoji
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComparatorThen(t *testing.T) {
+	byDamage := Higher(func(r Result) int { return r.Damage })
+	bySpd := Higher(func(r Result) int { return r.Spd })
+	cmp := byDamage.Then(bySpd)
+
+	assert.Equal(t, 1, cmp(Result{Damage: 2, Spd: 1}, Result{Damage: 1, Spd: 2}))
+	assert.Equal(t, -1, cmp(Result{Damage: 1, Spd: 1}, Result{Damage: 1, Spd: 2}))
+	assert.Equal(t, 0, cmp(Result{Damage: 1, Spd: 2}, Result{Damage: 1, Spd: 2}))
+}
+
+func TestBestSetsBreakTies(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+
+	shadow := Soul{Type: "Shadow", Crit: 10}
+	db := SoulDb{
+		Slot1: []Soul{{Type: "Shadow", Atk: 486}, {Type: "Shadow", Atk: 486, HP: 100}},
+		Slot2: []Soul{shadow}, Slot3: []Soul{shadow}, Slot4: []Soul{shadow},
+		Slot5: []Soul{{Type: "Odokuro", HP: 2052}},
+		Slot6: []Soul{{Type: "Odokuro", Spd: 57}, {Type: "Odokuro", Spd: 50}},
+	}
+	q := Query{Primaries: []string{"Shadow"}, Optimize: Damage, Evaluator: Evaluator{Shikigami: shiki}}
+
+	// Every set deals the same damage, so the default tie break prefers HP and then speed.
+	for i := 0; i < 10; i++ {
+		results := db.BestSets(4, q)
+		if assert.Len(t, results, 4) {
+			assert.Equal(t, 100, results[0].Souls.souls[0].HP)
+			assert.Equal(t, 57, results[0].Souls.souls[5].Spd)
+			assert.Equal(t, 100, results[1].Souls.souls[0].HP)
+			assert.Equal(t, 0, results[2].Souls.souls[0].HP)
+			assert.Equal(t, 57, results[2].Souls.souls[5].Spd)
+		}
+	}
+
+	// A tie break given by the query comes before the default.
+	q.TieBreak = Higher(func(r Result) int { return -r.Spd })
+	results := db.BestSets(4, q)
+	if assert.Len(t, results, 4) {
+		assert.Equal(t, 50, results[0].Souls.souls[5].Spd)
+		assert.Equal(t, 100, results[0].Souls.souls[0].HP)
+	}
+}
@@ -0,0 +1,235 @@
+package onmyoji
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// EffectKind is what a set effect changes.
+type EffectKind string
+
+// The kinds of set effect.
+const (
+	// DamageEffect multiplies damage by 1 + Multiplier.
+	DamageEffect EffectKind = "damage"
+	// ExtraDamageEffect deals extra damage of Multiplier times attack.
+	ExtraDamageEffect EffectKind = "extra damage"
+	// ShieldEffect gives a shield of Multiplier times max HP, which HP counts as extra HP.
+	ShieldEffect EffectKind = "shield"
+	// HealEffect multiplies healing by 1 + Multiplier.
+	HealEffect EffectKind = "heal"
+	// OtherEffect doesn't change anything the evaluators compute, such as control effects and
+	// counter attacks.
+	OtherEffect EffectKind = "other"
+)
+
+var effectKinds = []EffectKind{DamageEffect, ExtraDamageEffect, ShieldEffect, HealEffect, OtherEffect}
+
+// SetEffect is an effect that a set gets from having enough souls of one type.
+type SetEffect struct {
+	// Pieces is how many souls of the type the set needs, 2 or 4.
+	Pieces int
+	Kind   EffectKind
+	// Multiplier is the size of the effect, as described by its Kind.
+	Multiplier float64
+	// Chance is the chance that the effect applies when it could, from 0 to 1. Zero means it always
+	// applies.
+	Chance float64
+	// OnCrit only applies the effect to critical hits, so its chance is also scaled by crit.
+	OnCrit bool `yaml:"on_crit"`
+	// PerOrb scales the effect by the number of orbs in DamageOptions.
+	PerOrb bool `yaml:"per_orb"`
+	// PerHit triggers the effect on each hit rather than each attack, so only shikigami that hit
+	// several times per attack get it.
+	PerHit bool `yaml:"per_hit"`
+	// Description says what the effect does in the game.
+	Description string
+}
+
+// SoulType describes a soul type in the catalog.
+type SoulType struct {
+	Name string
+	// Bonus is the attribute that 2 souls of the type raise by 15%: "atk bonus", "crit",
+	// "hp bonus", "def bonus", "effect hit" or "" for none.
+	Bonus   string
+	Effects []SetEffect
+}
+
+// soulTypes is the catalog of soul types the planner knows. The effects approximate what each soul
+// does in an average fight; DefineSoulTypes can change them or add new types.
+var soulTypes = []SoulType{
+	{"harpy", "atk bonus", []SetEffect{{Pieces: 4, Kind: DamageEffect, Multiplier: 0.2, Chance: 0.5, Description: "50% chance for an attack to deal 20% more damage"}}},
+	{"watcher", "atk bonus", []SetEffect{{Pieces: 4, Kind: DamageEffect, Multiplier: 0.4, Description: "Up to 40% more damage against weakened enemies"}}},
+	{"house imp", "atk bonus", []SetEffect{{Pieces: 4, Kind: OtherEffect, Description: "Chance to gain action bar after attacking"}}},
+	{"scarlet", "atk bonus", []SetEffect{{Pieces: 4, Kind: OtherEffect, Description: "Chance to counter attack when hit"}}},
+	{"soultaker", "atk bonus", []SetEffect{{Pieces: 4, Kind: OtherEffect, Description: "Recovers HP from the damage dealt"}}},
+	{"nightwing", "atk bonus", []SetEffect{{Pieces: 4, Kind: DamageEffect, Multiplier: 0.15, Description: "15% more damage against enemies with debuffs"}}},
+	{"kyoukotsu", "atk bonus", []SetEffect{{Pieces: 4, Kind: DamageEffect, Multiplier: 0.08, PerOrb: true, Description: "8% more damage for each orb"}}},
+	{"tomb guard", "crit", []SetEffect{{Pieces: 4, Kind: DamageEffect, Multiplier: 0.2, Chance: 0.5, OnCrit: true, Description: "Critical hits have a 50% chance to ignore defense, for about 20% more damage"}}},
+	{"shadow", "crit", []SetEffect{{Pieces: 4, Kind: DamageEffect, Multiplier: 0.4, Description: "40% more damage against enemies with high HP"}}},
+	{"fenikkusu", "crit", []SetEffect{{Pieces: 4, Kind: OtherEffect, Description: "Gains action bar at the start of battle"}}},
+	{"claws", "crit", []SetEffect{{Pieces: 4, Kind: DamageEffect, Multiplier: 0.25, Chance: 0.5, Description: "50% chance to ignore 45% of defense, for about 25% more damage"}}},
+	{"samisen", "crit", []SetEffect{{Pieces: 4, Kind: OtherEffect, Description: "Chance to silence enemies"}}},
+	{"seductress", "crit", []SetEffect{{Pieces: 4, Kind: ExtraDamageEffect, Multiplier: 1.2, OnCrit: true, Description: "Critical hits deal extra damage of 120% of attack"}}},
+	{"tree spirit", "hp bonus", []SetEffect{{Pieces: 4, Kind: HealEffect, Multiplier: 0.2, Description: "Heals 20% more"}}},
+	{"soul edge", "hp bonus", []SetEffect{{Pieces: 4, Kind: OtherEffect, Description: "Damages attackers"}}},
+	{"priestess", "hp bonus", []SetEffect{{Pieces: 4, Kind: OtherEffect, Description: "Chance to remove debuffs"}}},
+	{"mirror lady", "hp bonus", []SetEffect{{Pieces: 4, Kind: OtherEffect, Description: "Reflects damage"}}},
+	{"boroboroton", "hp bonus", []SetEffect{{Pieces: 4, Kind: OtherEffect, Description: "Shares damage with allies"}}},
+	{"jizo statue", "hp bonus", []SetEffect{{Pieces: 4, Kind: OtherEffect, Description: "Chance to resist control effects"}}},
+	{"holy flame", "hp bonus", []SetEffect{{Pieces: 4, Kind: HealEffect, Multiplier: 0.15, Description: "Heals up to 30% more to allies with low HP"}}},
+	{"nuribotoke", "hp bonus", []SetEffect{{Pieces: 4, Kind: OtherEffect, Description: "Chance to revive"}}},
+	{"fortune cat", "def bonus", []SetEffect{{Pieces: 4, Kind: OtherEffect, Description: "Chance to gain an orb"}}},
+	{"azure basan", "effect hit", []SetEffect{{Pieces: 4, Kind: OtherEffect, Description: "Chance to inflict a burning debuff"}}},
+	{"namazu", "", []SetEffect{{Pieces: 2, Kind: ShieldEffect, Multiplier: 0.1, Description: "Shield of 10% of max HP at the start of battle"}}},
+	{"odokuro", "", []SetEffect{{Pieces: 2, Kind: DamageEffect, Multiplier: 0.1, Description: "Up to 10% more damage"}}},
+	{"tsuchigumo", "", []SetEffect{{Pieces: 2, Kind: ExtraDamageEffect, Multiplier: 0.2, Chance: 0.5, Description: "Webs that deal extra damage when the target is hit"}}},
+	{"ghostly songstress", "", []SetEffect{{Pieces: 2, Kind: ExtraDamageEffect, Multiplier: 2.55, Chance: 1.0 / 6, PerHit: true, Description: "Every 6th hit deals extra damage of 255% of attack"}}},
+}
+
+// Validate returns an error if the soul type has an unknown bonus, or an effect that isn't for 2
+// or 4 souls or has an unknown kind.
+func (t SoulType) Validate() error {
+	_, err := t.info()
+	return err
+}
+
+func (t SoulType) info() (kindInfo, error) {
+	info := kindInfo{name: strings.ToLower(t.Name), effects: t.Effects}
+	if info.name == "" {
+		return info, fmt.Errorf("soul type must have a name")
+	}
+	found := false
+	for b, name := range bonusNames {
+		if strings.EqualFold(t.Bonus, name) {
+			info.bonus, found = bonus(b), true
+		}
+	}
+	if !found {
+		return info, fmt.Errorf("%v: unknown bonus %q, must be one of %q", t.Name, t.Bonus, bonusNames)
+	}
+	for _, e := range t.Effects {
+		if e.Pieces != 2 && e.Pieces != 4 {
+			return info, fmt.Errorf("%v: effects must be for 2 or 4 souls, not %v", t.Name, e.Pieces)
+		}
+		if !knownEffect(e.Kind) {
+			return info, fmt.Errorf("%v: unknown effect kind %q, must be one of %q", t.Name, e.Kind, effectKinds)
+		}
+		if e.Chance < 0 || e.Chance > 1 {
+			return info, fmt.Errorf("%v: effect chance must be between 0 and 1", t.Name)
+		}
+		// Searches bound sets assuming effects only ever add, so they can't take anything away.
+		if e.Multiplier < 0 {
+			return info, fmt.Errorf("%v: effect multiplier must not be negative", t.Name)
+		}
+	}
+	return info, nil
+}
+
+func knownEffect(k EffectKind) bool {
+	for _, known := range effectKinds {
+		if k == known {
+			return true
+		}
+	}
+	return false
+}
+
+// DefineSoulTypes adds soul types to the catalog, replacing the bonus and effects of types it
+// already has. If any type isn't valid, it returns an error and changes nothing. It must not be
+// called while searching.
+func DefineSoulTypes(types []SoulType) error {
+	infos := make([]kindInfo, len(types))
+	for i, t := range types {
+		var err error
+		if infos[i], err = t.info(); err != nil {
+			return err
+		}
+	}
+	for _, info := range infos {
+		k := internKind(info.name)
+		for int(k) >= len(knownKinds) {
+			knownKinds = append(knownKinds, kindInfo{})
+		}
+		knownKinds[k] = info
+	}
+	return nil
+}
+
+// SoulTypesFingerprint returns a hash of the soul type catalog, which changes whenever
+// DefineSoulTypes changes the bonus or effects of a type, or adds a type.
+func SoulTypesFingerprint() uint64 {
+	var infos []kindInfo
+	for _, info := range knownKinds {
+		// Types are defined in the order they were first seen, so sort them by name.
+		if info.name != "" {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].name < infos[j].name })
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%#v", infos)
+	return h.Sum64()
+}
+
+// chance returns the chance that the effect applies to an attack with the crit chance.
+func (e *SetEffect) chance(crit float64) float64 {
+	p := e.Chance
+	if p == 0 {
+		p = 1
+	}
+	if e.OnCrit {
+		p *= crit
+	}
+	return p
+}
+
+// effects combines the expected sizes of the set effects of a kind that the set has enough souls
+// for, returning their sum and the product of one plus each size. Effects that only trigger per hit
+// are left out unless the shikigami is Multihit.
+func (set *SoulSet) effects(k EffectKind, shiki Shikigami, crit float64, orbs int) (sum, product float64) {
+	product = 1
+	for i := int8(0); i < set.counts.len; i++ {
+		effects := set.counts.kinds[i].info().effects
+		for j := range effects {
+			e := &effects[j]
+			if e.Kind != k || int(set.counts.n[i]) < e.Pieces || (e.PerHit && !shiki.Multihit) {
+				continue
+			}
+			size := e.chance(crit) * e.Multiplier
+			if e.PerOrb {
+				size *= float64(orbs)
+			}
+			sum += size
+			product *= 1 + size
+		}
+	}
+	return sum, product
+}
+
+// changes returns whether an effect can change the field.
+func (e *SetEffect) changes(f field) bool {
+	switch e.Kind {
+	case DamageEffect, ExtraDamageEffect:
+		return f == damageField
+	case ShieldEffect:
+		return f == hpField
+	case HealEffect:
+		return f == healField
+	}
+	return false
+}
+
+// pieces returns the fewest souls of a type that give a set effect changing the field, or 0 if no
+// number of souls does.
+func (f field) pieces(k kind) int {
+	fewest := 0
+	for _, e := range k.info().effects {
+		if e.changes(f) && (fewest == 0 || e.Pieces < fewest) {
+			fewest = e.Pieces
+		}
+	}
+	return fewest
+}
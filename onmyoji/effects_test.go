@@ -0,0 +1,73 @@
+package onmyoji
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// whole truncates a number the way the evaluators do.
+func whole(x float64) int {
+	return int(x)
+}
+
+func TestSetEffects(t *testing.T) {
+	shiki := Shikigami{HP: 10000, Atk: 1000, Crit: 50, CritDmg: 200}
+	set := func(four, two string) SoulSet {
+		return NewSoulSet([6]Soul{{Type: four}, {Type: four}, {Type: four}, {Type: four}, {Type: two}, {Type: two}})
+	}
+	opts := DamageOptions{Orbs: 5}
+
+	// Crit pairs add 15% crit, so sets of Shadow or Seductress have 65% crit; the base damage is
+	// then 1000 * (0.65*2 + 0.35) = 1650.
+	assert.Equal(t, 1650, set("Shadow", "Namazu").Damage(shiki, Modifiers{}, DamageOptions{IgnoreSetBonus: true}))
+	assert.Equal(t, whole(1650*1.4), set("Shadow", "Namazu").Damage(shiki, Modifiers{}, opts))
+	// Seductress adds 120% of attack on crits, after Odokuro's 10% applies to the attack.
+	assert.Equal(t, whole(1650*1.1+1.2*0.65*1000), set("Seductress", "Odokuro").Damage(shiki, Modifiers{}, opts))
+	// Kyoukotsu scales with orbs, and Ghostly Songstress only helps multi-hit shikigami. Atk bonus
+	// pairs add 15% attack each.
+	assert.Equal(t, whole(1300*1.5*1.4), set("Kyoukotsu", "Harpy").Damage(shiki, Modifiers{}, opts))
+	assert.Equal(t, whole(1150*1.5*1.4), set("Kyoukotsu", "Ghostly Songstress").Damage(shiki, Modifiers{}, opts))
+	shiki.Multihit = true
+	assert.Equal(t, whole(1150*1.5*1.4+2.55*1150/6), set("Kyoukotsu", "Ghostly Songstress").Damage(shiki, Modifiers{}, opts))
+
+	// Namazu's shield counts towards HP but not healing, and Tree Spirit heals more.
+	assert.Equal(t, 11000, set("Shadow", "Namazu").HP(shiki, Modifiers{}))
+	assert.Equal(t, whole(11500*1.5*1.2), set("Tree Spirit", "Odokuro").Heal(shiki, Modifiers{}))
+	assert.Equal(t, 11500, set("Tree Spirit", "Odokuro").HP(shiki, Modifiers{}))
+}
+
+func TestSeductressOdokuroDamage(t *testing.T) {
+	// The damage this set gave before set effects came from the catalog, which added Seductress's
+	// extra damage after Odokuro's increase.
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	set := NewSoulSet([6]Soul{
+		{Type: "Seductress", Atk: 486, Crit: 8}, {Type: "Seductress", AtkBonus: 55, Spd: 5},
+		{Type: "Seductress", Crit: 10}, {Type: "Seductress", AtkBonus: 55, CritDmg: 7},
+		{Type: "Odokuro", HP: 2052, Crit: 6}, {Type: "Odokuro", CritDmg: 89, Crit: 4},
+	})
+	assert.Equal(t, 20115, set.Damage(shiki, Modifiers{}, DamageOptions{Orbs: 5}))
+}
+
+func TestDefineSoulTypes(t *testing.T) {
+	assert.Error(t, SoulType{Name: "Test Soul", Bonus: "luck"}.Validate())
+	assert.Error(t, SoulType{Name: "Test Soul", Effects: []SetEffect{{Pieces: 3, Kind: DamageEffect}}}.Validate())
+	assert.Error(t, SoulType{Name: "Test Soul", Effects: []SetEffect{{Pieces: 4, Kind: "luck"}}}.Validate())
+	assert.Error(t, SoulType{Name: "Test Soul", Effects: []SetEffect{{Pieces: 2, Kind: DamageEffect, Multiplier: -0.1}}}.Validate())
+	assert.Error(t, DefineSoulTypes([]SoulType{{Name: "Test Soul", Bonus: "luck"}}))
+	_, err := SoulSetBonus("Test Soul")
+	assert.Error(t, err)
+
+	before := SoulTypesFingerprint()
+	assert.NoError(t, DefineSoulTypes([]SoulType{{Name: "Test Soul", Bonus: "atk bonus", Effects: []SetEffect{{Pieces: 4, Kind: DamageEffect, Multiplier: 0.5, Chance: 0.5}}}}))
+	assert.NotEqual(t, before, SoulTypesFingerprint())
+	bonus, err := SoulSetBonus("test soul")
+	assert.NoError(t, err)
+	assert.Equal(t, "atk bonus", bonus)
+
+	shiki := Shikigami{Atk: 1000, CritDmg: 150}
+	set := NewSoulSet([6]Soul{{Type: "Test Soul"}, {Type: "Test Soul"}, {Type: "Test Soul"}, {Type: "Test Soul"}})
+	assert.Equal(t, whole(1150*1.25), set.Damage(shiki, Modifiers{}, DamageOptions{}))
+	assert.True(t, damageField.affectedBy(internKind("Test Soul")))
+}
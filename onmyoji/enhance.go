@@ -0,0 +1,118 @@
+package onmyoji
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// MaxLevel is the highest level a soul can be enhanced to.
+const MaxLevel = 15
+
+// mainGrowth is how much a 6 star soul's main stat grows each level, in the order of soulStats.
+var mainGrowth = [len(soulStats)]float64{27, 3, 3, 5, 3, 114, 3, 6, 3, 3, 3}
+
+// subRoll is the average size of a substat roll on a 6 star soul, in the order of soulStats. These
+// approximate the game's rolls, which vary.
+var subRoll = [len(soulStats)]float64{24, 2.5, 2.5, 3.5, 2.5, 103, 2.5, 5, 2.5, 3.5, 3.5}
+
+// statIndex returns the position of the named stat in soulStats, or -1 if there's no such stat.
+func statIndex(name string) int {
+	for i, n := range statNames {
+		if strings.EqualFold(name, n) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Unfinished returns whether the soul can still be enhanced and records enough to project its
+// stats: its stars, level and main stat.
+func (s Soul) Unfinished() bool {
+	return s.Stars > 0 && s.Level < MaxLevel && s.Main.Name != ""
+}
+
+// Projected returns the stats the soul is expected to have once enhanced to +15. Its main stat
+// grows by the game's amount for each level, and each substat gains its share of the rolls still
+// to come at +3, +6, +9, +12 and +15, shared evenly between 4 substats. Rolls that would add a new
+// substat are left out, as which stat they add is random. Souls with fewer than 6 stars grow in
+// proportion to their stars. Souls that aren't Unfinished are returned as they are.
+func (s Soul) Projected() Soul {
+	if !s.Unfinished() {
+		return s
+	}
+	scale := float64(s.Stars) / 6
+	levels := float64(MaxLevel - s.Level)
+	rolls := float64(MaxLevel/3 - s.Level/3)
+
+	subs := s.Substats()
+	stats := s.stats()
+	if i := statIndex(s.Main.Name); i >= 0 {
+		grow := int(math.Round(mainGrowth[i] * scale * levels))
+		*stats[i] += grow
+		s.Main.Value += grow
+	}
+	for _, sub := range subs {
+		i := statIndex(sub.Name)
+		*stats[i] += int(math.Round(subRoll[i] * scale * rolls / 4))
+	}
+	s.Level = MaxLevel
+	return s
+}
+
+// CheckLevels returns an error if a soul has stars or a level that souls can't have.
+func (db *SoulDb) CheckLevels() error {
+	for _, slot := range db.slots() {
+		for _, sl := range *slot {
+			if sl.Stars < 0 || sl.Stars > 6 {
+				return fmt.Errorf("soul %v: stars must be from 1 to 6, not %v", sl, sl.Stars)
+			}
+			if sl.Level < 0 || sl.Level > MaxLevel {
+				return fmt.Errorf("soul %v: level must be from 0 to %v, not %v", sl, MaxLevel, sl.Level)
+			}
+		}
+	}
+	return nil
+}
+
+// Projected returns a copy of the database with each unfinished soul replaced by its projection at
+// +15, so searches pick souls for what they will become. The copy remembers the souls as they are
+// now, which Current returns and Describe notes.
+func (db *SoulDb) Projected() SoulDb {
+	projected := db.Clone()
+	projected.current = make(map[int]Soul)
+	for k, slot := range projected.slots() {
+		for i, sl := range *slot {
+			if sl.Unfinished() && sl.ID != 0 {
+				projected.current[sl.ID] = (*db.slots()[k])[i]
+				(*slot)[i] = sl.Projected()
+			}
+		}
+	}
+	return projected
+}
+
+// Current returns the set with its souls as they are now, before the enhancing that Projected
+// assumes, and whether any of its souls were projected.
+func (db *SoulDb) Current(set SoulSet) (SoulSet, bool) {
+	souls := set.souls
+	projected := false
+	for k, sl := range souls {
+		if now, ok := db.current[sl.ID]; ok && sl.ID != 0 {
+			souls[k], projected = now, true
+		}
+	}
+	if !projected {
+		return set, false
+	}
+	return NewSoulSet(souls), true
+}
+
+// enhanceNote notes that a soul was projected, giving its level and stats now.
+func (db *SoulDb) enhanceNote(sl Soul) string {
+	now, ok := db.current[sl.ID]
+	if !ok || sl.ID == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (needs enhancing from +%v, now %v)", now.Level, now.statsString())
+}
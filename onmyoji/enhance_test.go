@@ -0,0 +1,62 @@
+package onmyoji
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjected(t *testing.T) {
+	sl := Soul{ID: 1, Type: "Seductress", Stars: 6, Level: 9, Spd: 39, Crit: 5, Atk: 20, Main: Stat{"spd", 39}}
+	assert.True(t, sl.Unfinished())
+	// Spd grows 3 a level, and the 2 rolls left at +12 and +15 are shared between 4 substats.
+	assert.Equal(t, Soul{ID: 1, Type: "Seductress", Stars: 6, Level: 15, Spd: 57, Crit: 6, Atk: 32, Main: Stat{"spd", 57}}, sl.Projected())
+	assert.False(t, sl.Projected().Unfinished())
+
+	// Souls with fewer stars grow less.
+	low := Soul{Type: "Shadow", Stars: 3, Atk: 40, Main: Stat{"atk", 40}}
+	assert.Equal(t, 243, low.Projected().Atk)
+
+	// Souls that don't record enough to project stay as they are.
+	for _, same := range []Soul{
+		{Type: "Shadow", Level: 3, Atk: 100, Main: Stat{"atk", 100}},
+		{Type: "Shadow", Stars: 6, Level: 3, Atk: 100},
+		{Type: "Shadow", Stars: 6, Level: 15, Atk: 486, Main: Stat{"atk", 486}},
+	} {
+		assert.Equal(t, same, same.Projected())
+	}
+
+	assert.NoError(t, (&SoulDb{Slot1: []Soul{sl, low}}).CheckLevels())
+	assert.Error(t, (&SoulDb{Slot1: []Soul{{Type: "Shadow", Stars: 7}}}).CheckLevels())
+	assert.Error(t, (&SoulDb{Slot1: []Soul{{Type: "Shadow", Stars: 6, Level: 16}}}).CheckLevels())
+}
+
+func TestSoulDbProjected(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	ev := Evaluator{Shikigami: shiki, Options: DamageOptions{Orbs: 5}}
+
+	db := randomDb(1, 3)
+	db.AssignIDs()
+	// A +0 soul with a crit damage main stat only beats the others once enhanced.
+	unfinished := Soul{ID: 100, Type: "Seductress", Stars: 6, CritDmg: 14, Crit: 15, AtkBonus: 15, Main: Stat{"critdmg", 14}}
+	db.Slot6 = append(db.Slot6, unfinished)
+
+	q := Query{Optimize: Damage, Evaluator: ev}
+	assert.NotEqual(t, 100, db.BestSet(q).Souls.Souls()[5].ID)
+
+	projected := db.Projected()
+	assert.Equal(t, unfinished, db.Slot6[len(db.Slot6)-1], "Projected changed the database")
+	best := projected.BestSet(q)
+	assert.Equal(t, 100, best.Souls.Souls()[5].ID)
+	assert.Equal(t, 89, best.Souls.Souls()[5].Main.Value)
+	assert.Contains(t, projected.Describe(best), "needs enhancing from +0")
+
+	now, ok := projected.Current(best.Souls)
+	assert.True(t, ok)
+	assert.Equal(t, unfinished, now.Souls()[5])
+	assert.True(t, ev.Evaluate(now).Damage < best.Damage)
+
+	_, ok = db.Current(best.Souls)
+	assert.False(t, ok)
+}
@@ -0,0 +1,80 @@
+package onmyoji
+
+import (
+	"time"
+)
+
+// Estimate describes how much work a search could take.
+type Estimate struct {
+	// Combinations counts the sets of souls that match the query's layout, or primaries and
+	// secondaries, after leaving out souls that can't be in the best sets. The search skips most of
+	// them.
+	Combinations float64
+	// Pruned counts, for each slot, the souls left out because enough other souls of the same type
+	// were at least as good.
+	Pruned [6]int
+	// Time is how long evaluating every combination would take on the query's workers. Searches
+	// are usually much faster, because they skip partial sets that can't beat the sets already
+	// found.
+	Time time.Duration
+}
+
+// estimateSamples is how many sets Estimate evaluates to time them.
+const estimateSamples = 2000
+
+// Estimate works out how much work BestSets(n, q) could take, without searching. It returns an
+// error if the query isn't valid.
+func (db *SoulDb) Estimate(n int, q Query) (Estimate, error) {
+	if err := q.Validate(); err != nil {
+		return Estimate{}, err
+	}
+	objs := []*objective{q.Optimize.objective()}
+	slots := [6][]Soul{db.Slot1, db.Slot2, db.Slot3, db.Slot4, db.Slot5, db.Slot6}
+	est := Estimate{Pruned: q.prune(&slots, objs, n)}
+	for _, slot := range slots {
+		if len(slot) == 0 {
+			return est, nil
+		}
+	}
+
+	// Count the combinations for each way the types of a partial set can match, as the matcher
+	// only cares about how many souls of each type the set has.
+	m := newMatcher(&q)
+	counts := map[matched]float64{{}: 1}
+	for k, slot := range slots {
+		byKind := make(map[kind]float64)
+		for _, sl := range slot {
+			byKind[internKind(sl.Type)]++
+		}
+		next := make(map[matched]float64)
+		for st, c := range counts {
+			for typ, souls := range byKind {
+				if st, ok := m.match(k, typ, st); ok {
+					next[st] += c * souls
+				}
+			}
+		}
+		counts = next
+	}
+	for _, c := range counts {
+		est.Combinations += c
+	}
+
+	// Time evaluating a sample of sets the way a search does.
+	obj := objs[0]
+	var stats Stats
+	stats.Rejected = make([]int64, len(q.Constraints))
+	start := time.Now()
+	for i := 0; i < estimateSamples; i++ {
+		var souls [6]Soul
+		for k, slot := range slots {
+			souls[k] = slot[(i*(k+1))%len(slot)]
+		}
+		set := NewSoulSet(souls)
+		obj.evaluate(q.Evaluator, set)
+		q.accept(q.Evaluator.Evaluate(set), &stats)
+	}
+	perSet := float64(time.Since(start)) / estimateSamples
+	est.Time = time.Duration(perSet * est.Combinations / float64(q.workers()))
+	return est, nil
+}
@@ -0,0 +1,53 @@
+package onmyoji
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateCountsMatchingSets(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	ev := Evaluator{Shikigami: shiki}
+	db := randomDb(2, 6)
+
+	count := func(valid func(SoulSet) bool) float64 {
+		n := 0.0
+		for _, s1 := range db.Slot1 {
+			for _, s2 := range db.Slot2 {
+				for _, s3 := range db.Slot3 {
+					for _, s4 := range db.Slot4 {
+						for _, s5 := range db.Slot5 {
+							for _, s6 := range db.Slot6 {
+								if valid(NewSoulSet([6]Soul{s1, s2, s3, s4, s5, s6})) {
+									n++
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+		return n
+	}
+
+	// With enough sets requested, no souls are pruned.
+	est, err := db.Estimate(1000, Query{Primaries: []string{"Shadow"}, Optimize: Damage, Evaluator: ev})
+	assert.NoError(t, err)
+	assert.Equal(t, [6]int{}, est.Pruned)
+	assert.Equal(t, count(withShadow), est.Combinations)
+	assert.True(t, est.Time > 0)
+
+	est, err = db.Estimate(1000, Query{Optimize: Damage, Evaluator: ev})
+	assert.NoError(t, err)
+	assert.Equal(t, count(withPairs), est.Combinations)
+
+	pruned, err := db.Estimate(1, Query{Optimize: Damage, Evaluator: ev})
+	assert.NoError(t, err)
+	assert.NotEqual(t, [6]int{}, pruned.Pruned)
+	assert.True(t, pruned.Combinations < est.Combinations)
+
+	_, err = db.Estimate(1, Query{Optimize: Optimizer("atk +"), Evaluator: ev})
+	assert.Error(t, err)
+}
@@ -0,0 +1,422 @@
+package onmyoji
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Strategy picks how BestSets looks for the best sets.
+type Strategy string
+
+const (
+	// Exhaustive checks every combination of souls, only skipping those that can't beat the sets
+	// already found, so it always finds the best sets.
+	Exhaustive Strategy = "exhaustive"
+	// Anneal improves a set one soul at a time by simulated annealing, sometimes accepting a worse
+	// set so that it doesn't get stuck on a set that no single change improves.
+	Anneal Strategy = "anneal"
+	// Genetic breeds a population of sets, mixing the slots of good sets and changing a few souls.
+	Genetic Strategy = "genetic"
+	// Beam builds sets one slot at a time, keeping only the partial sets whose completions could
+	// reach the highest values.
+	Beam Strategy = "beam"
+)
+
+var strategies = []Strategy{Exhaustive, Anneal, Genetic, Beam}
+
+// Validate returns an error if the strategy isn't one of Exhaustive, Anneal, Genetic or Beam. An
+// empty strategy is Exhaustive.
+func (s Strategy) Validate() error {
+	if s == "" {
+		return nil
+	}
+	for _, known := range strategies {
+		if s == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown strategy %v, must be one of %v", s, strategies)
+}
+
+// heuristic returns whether the strategy might miss the best sets.
+func (s Strategy) heuristic() bool {
+	return s != "" && s != Exhaustive
+}
+
+const (
+	// defaultEvaluations is how many sets a heuristic search evaluates if the query doesn't say.
+	defaultEvaluations = 400000
+	// heuristicRuns is how many independent runs Anneal and Genetic split their evaluations
+	// between, each seeded differently. It doesn't depend on the number of workers, so that the
+	// same seed always finds the same sets.
+	heuristicRuns = 8
+	// populationSize is how many sets Genetic breeds from.
+	populationSize = 100
+)
+
+// genes picks a soul from each slot by its index in the slot.
+type genes [6]int
+
+// heuristic looks for good sets without checking every combination. Each run has its own.
+type heuristic struct {
+	*Query
+	m     matcher
+	obj   *objective
+	slots *[6][]Soul
+	kinds *[6][]kind
+	// byKind[k] lists the indexes of the souls in slot k of each type.
+	byKind *[6]map[kind][]int
+	// penalty is how much each soul of the wrong type, or each constraint missed by its own size,
+	// lowers the fitness of a set.
+	penalty float64
+	rank    *ranking
+	cp      completion
+	bound   *upperBound
+	rng     *rand.Rand
+	stop    *int32
+	// budget is how many sets the run may evaluate.
+	budget int64
+	stats  Stats
+}
+
+// upperBound collects nothing, but records the highest value the objective could reach for any of
+// the completions it is asked to admit.
+type upperBound struct {
+	obj *objective
+	max float64
+}
+
+func (u *upperBound) admits(lo, hi *fieldValues) bool {
+	if v := u.obj.upper(lo, hi); v > u.max {
+		u.max = v
+	}
+	return false
+}
+
+func (u *upperBound) collect(*Query, SoulSet, *Stats) {}
+
+// upperBound returns the highest value the objective could reach for any set of the slots that
+// matches the query and satisfies its constraints, or -Inf if none can.
+func (q *Query) upperBound(slots [6][]Soul, obj *objective) float64 {
+	for _, slot := range slots {
+		if len(slot) == 0 {
+			return math.Inf(-1)
+		}
+	}
+	kinds := internSlots(&slots)
+	bound := &upperBound{obj: obj, max: math.Inf(-1)}
+	cp := completion{bounds: newBounds(slots, &kinds, []*objective{obj}, q, newMatcher(q)), ev: q.Evaluator, collector: bound}
+	cp.canBeat([6]Soul{}, [6]kind{}, 0)
+	return bound.max
+}
+
+// upperBound returns the highest value the objective could reach for any completion of the first k
+// souls that matches the query and satisfies its constraints, or -Inf if none can.
+func (h *heuristic) upperBound(g *genes, k int) float64 {
+	var souls [6]Soul
+	var kinds [6]kind
+	for i := 0; i < k; i++ {
+		souls[i], kinds[i] = h.slots[i][g[i]], h.kinds[i][g[i]]
+	}
+	h.bound.max = math.Inf(-1)
+	h.cp.canBeat(souls, kinds, k)
+	return h.bound.max
+}
+
+// done returns whether the run has used its budget or should stop early.
+func (h *heuristic) done() bool {
+	return h.stats.Completed >= h.budget || atomic.LoadInt32(h.stop) != 0
+}
+
+// fitness evaluates a set, collecting it if it matches the query's soul types. Sets of the wrong
+// types or outside the constraints score lower the further they are from fitting, so that a run
+// can find its way to sets that do.
+func (h *heuristic) fitness(g *genes) float64 {
+	var souls [6]Soul
+	var kinds [6]kind
+	for k, i := range g {
+		souls[k], kinds[k] = h.slots[k][i], h.kinds[k][i]
+	}
+	set := newSoulSet(souls, kinds)
+	h.stats.Completed++
+
+	missed := h.wrongTypes(&kinds)
+	if missed == 0 {
+		h.rank.collect(h.Query, set, &h.stats)
+	}
+	for _, l := range h.cp.limits {
+		v := float64(l.field.compute(h.Evaluator, set))
+		if v < l.min {
+			missed += (l.min - v) / math.Max(1, math.Abs(l.min))
+		} else if v > l.max {
+			missed += (v - l.max) / math.Max(1, math.Abs(l.max))
+		}
+	}
+	return h.obj.evaluate(h.Evaluator, set) - h.penalty*missed
+}
+
+// wrongTypes counts the souls that would need a different type for the set to match the query.
+func (h *heuristic) wrongTypes(kinds *[6]kind) float64 {
+	if h.m.layout != nil {
+		var counts kindCounts
+		for _, typ := range kinds {
+			counts.add(typ)
+		}
+		return float64(h.m.layout.shortfall(&counts))
+	}
+	var st matched
+	wrong := 0
+	for _, typ := range kinds {
+		if next, ok := h.m.add(typ, st); ok {
+			st = next
+		} else {
+			wrong++
+		}
+	}
+	if len(h.m.primaries) > 0 && st.primCount < 4 {
+		wrong += 4 - st.primCount
+	}
+	return float64(wrong)
+}
+
+// random picks a random set. When the query has primaries, or a layout with a group of 4 souls of
+// some types, 4 of its souls are of a random one of those types where the slots have enough of them.
+func (h *heuristic) random() genes {
+	var g genes
+	for k, slot := range h.slots {
+		g[k] = h.rng.Intn(len(slot))
+	}
+	if len(h.m.primaries) == 0 {
+		return g
+	}
+	p := h.m.primaries[h.rng.Intn(len(h.m.primaries))]
+	for n, k := range h.rng.Perm(6) {
+		if n == 4 {
+			break
+		}
+		if same := h.byKind[k][p]; len(same) > 0 {
+			g[k] = same[h.rng.Intn(len(same))]
+		}
+	}
+	return g
+}
+
+// mutate swaps the soul in a random slot, for another of the same type half of the time.
+func (h *heuristic) mutate(g *genes) {
+	k := h.rng.Intn(6)
+	if h.rng.Intn(2) == 0 {
+		same := h.byKind[k][h.kinds[k][g[k]]]
+		g[k] = same[h.rng.Intn(len(same))]
+	} else {
+		g[k] = h.rng.Intn(len(h.slots[k]))
+	}
+}
+
+// anneal changes one soul at a time, keeping every change that doesn't lower the fitness and some
+// that do. Worse changes are kept less often as the run cools down.
+func (h *heuristic) anneal() {
+	g := h.random()
+	fit := h.fitness(&g)
+	start := h.penalty / 20
+	for !h.done() {
+		progress := float64(h.stats.Completed) / float64(h.budget)
+		temp := start * math.Pow(1e-4, progress)
+		next := g
+		h.mutate(&next)
+		nextFit := h.fitness(&next)
+		if nextFit >= fit || h.rng.Float64() < math.Exp((nextFit-fit)/temp) {
+			g, fit = next, nextFit
+		}
+	}
+}
+
+// genetic breeds children from pairs of sets that each beat another random set, mixing their slots
+// and sometimes mutating them. A child replaces the weakest set when it is fitter.
+func (h *heuristic) genetic() {
+	population := make([]genes, populationSize)
+	fit := make([]float64, populationSize)
+	for i := range population {
+		population[i] = h.random()
+		fit[i] = h.fitness(&population[i])
+	}
+	parent := func() genes {
+		a, b := h.rng.Intn(populationSize), h.rng.Intn(populationSize)
+		if fit[b] > fit[a] {
+			a = b
+		}
+		return population[a]
+	}
+
+	for !h.done() {
+		child, other := parent(), parent()
+		for k := range child {
+			if h.rng.Intn(2) == 0 {
+				child[k] = other[k]
+			}
+		}
+		if h.rng.Intn(2) == 0 {
+			h.mutate(&child)
+		}
+		childFit := h.fitness(&child)
+
+		weakest, present := 0, false
+		for i := range population {
+			present = present || population[i] == child
+			if fit[i] < fit[weakest] {
+				weakest = i
+			}
+		}
+		// Copies of a set would crowd out the variety the population needs.
+		if !present && childFit > fit[weakest] {
+			population[weakest], fit[weakest] = child, childFit
+		}
+	}
+}
+
+// beam extends partial sets one slot at a time with every soul that matches the query, keeping only
+// the width partial sets with the highest upper bounds. It doesn't use the seed.
+func (h *heuristic) beam(width int) {
+	type partial struct {
+		g     genes
+		st    matched
+		bound float64
+	}
+	beam := []partial{{}}
+	for k := 0; k < 6; k++ {
+		var next []partial
+		tried := 0
+		for _, p := range beam {
+			if h.done() {
+				return
+			}
+			for i, typ := range h.kinds[k] {
+				st, ok := h.m.match(k, typ, p.st)
+				if !ok {
+					continue
+				}
+				g := p.g
+				g[k] = i
+				tried++
+				var bound float64
+				if k < 5 {
+					bound = h.upperBound(&g, k+1)
+				} else {
+					bound = h.fitness(&g)
+				}
+				if !math.IsInf(bound, -1) {
+					next = append(next, partial{g, st, bound})
+				}
+			}
+		}
+		sort.SliceStable(next, func(i, j int) bool { return next[i].bound > next[j].bound })
+		if len(next) > width {
+			next = next[:width]
+		}
+		if k < 5 {
+			h.stats.Skipped += int64(tried - len(next))
+		}
+		beam = next
+		h.stats.Covered = float64(k+1) / 6
+	}
+}
+
+// heuristicSearch looks for the n best sets of the slots with the query's Strategy. Stats.Bound
+// holds an upper bound on the optimized value of any acceptable set.
+func (q *Query) heuristicSearch(ctx context.Context, slots [6][]Soul, obj *objective, n int) ([]Result, Stats, error) {
+	start := time.Now()
+	rank := newRanking(obj, q.Comparator(), n)
+	// Nothing can beat the bound for the whole query, and it sets the scale of the fitness penalties.
+	stats := Stats{Bound: q.upperBound(slots, obj)}
+	if math.IsInf(stats.Bound, -1) {
+		stats.Covered = 1
+		stats.Elapsed = time.Since(start)
+		return nil, stats, nil
+	}
+	penalty := math.Max(1, math.Abs(stats.Bound))
+
+	kinds := internSlots(&slots)
+	var byKind [6]map[kind][]int
+	for k, slot := range kinds {
+		byKind[k] = make(map[kind][]int)
+		for i, typ := range slot {
+			byKind[k][typ] = append(byKind[k][typ], i)
+		}
+	}
+	m := newMatcher(q)
+	b := newBounds(slots, &kinds, []*objective{obj}, q, m)
+	stop, release := stopWhenDone(ctx)
+	defer release()
+
+	newRun := func(seed int64, budget int64) *heuristic {
+		bound := &upperBound{obj: obj}
+		h := &heuristic{
+			Query: q, m: m, obj: obj, slots: &slots, kinds: &kinds, byKind: &byKind,
+			penalty: penalty, rank: rank, cp: completion{bounds: b, ev: q.Evaluator, collector: bound}, bound: bound,
+			rng: rand.New(rand.NewSource(seed)), stop: stop, budget: budget,
+		}
+		h.stats.Rejected = make([]int64, len(q.Constraints))
+		return h
+	}
+
+	evaluations := q.Evaluations
+	if evaluations <= 0 {
+		evaluations = defaultEvaluations
+	}
+	var runs []*heuristic
+	if q.Strategy == Beam {
+		total := 0
+		for _, slot := range slots {
+			total += len(slot)
+		}
+		width := evaluations / total
+		if width < n {
+			width = n
+		}
+		h := newRun(q.Seed, math.MaxInt64)
+		h.beam(width)
+		runs = append(runs, h)
+	} else {
+		runs = make([]*heuristic, heuristicRuns)
+		for i := range runs {
+			runs[i] = newRun(q.Seed+int64(i), int64(evaluations/heuristicRuns))
+		}
+		work := make(chan *heuristic, len(runs))
+		for _, h := range runs {
+			work <- h
+		}
+		close(work)
+		var wg sync.WaitGroup
+		for i := 0; i < q.workers(); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for h := range work {
+					if q.Strategy == Anneal {
+						h.anneal()
+					} else {
+						h.genetic()
+					}
+					h.stats.Covered = math.Min(1, float64(h.stats.Completed)/float64(h.budget)) / heuristicRuns
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	stats.Rejected = make([]int64, len(q.Constraints))
+	for _, h := range runs {
+		stats.add(h.stats)
+	}
+	stats.Elapsed = time.Since(start)
+	if atomic.LoadInt32(stop) != 0 {
+		return rank.results, stats, ctx.Err()
+	}
+	stats.Covered = 1
+	return rank.results, stats, nil
+}
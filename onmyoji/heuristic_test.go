@@ -0,0 +1,69 @@
+package onmyoji
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrategyValidate(t *testing.T) {
+	for _, s := range []Strategy{"", Exhaustive, Anneal, Genetic, Beam} {
+		assert.NoError(t, s.Validate())
+	}
+	assert.Error(t, Strategy("greedy").Validate())
+}
+
+func TestHeuristicStrategies(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	db := randomDb(3, 10)
+	q := Query{
+		Primaries:   []string{"Seductress", "Shadow"},
+		Optimize:    Damage,
+		Evaluator:   Evaluator{Shikigami: shiki, Options: DamageOptions{Orbs: 5}},
+		Constraints: []Constraint{{Attribute: "crit", Min: 80}, {Attribute: "spd", Min: 115, Max: 130}},
+		Evaluations: 20000,
+	}
+
+	exact, stats, err := db.BestSetsContext(context.Background(), 3, q)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, exact)
+	best := Damage.Value(exact[0])
+	assert.Equal(t, best, stats.Bound)
+
+	for _, s := range []Strategy{Anneal, Genetic, Beam} {
+		q.Strategy = s
+		results, stats, err := db.BestSetsContext(context.Background(), 3, q)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, results, "%v", s)
+		assert.Equal(t, 1.0, stats.Covered, "%v", s)
+		assert.True(t, stats.Bound >= best, "%v: bound %v below %v", s, stats.Bound, best)
+		for _, r := range results {
+			assert.True(t, Damage.Value(r) <= best, "%v", s)
+			assert.True(t, r.Crit >= 80 && r.Spd >= 115 && r.Spd <= 130, "%v: %v", s, r)
+			assert.True(t, r.Souls.Count("Seductress") >= 4 || r.Souls.Count("Shadow") >= 4, "%v: %v", s, r)
+		}
+
+		// The same seed finds the same sets, however many workers there are.
+		q.Workers = 3
+		again, _, err := db.BestSetsContext(context.Background(), 3, q)
+		assert.NoError(t, err)
+		assert.Equal(t, results, again, "%v", s)
+		q.Workers = 0
+	}
+}
+
+func TestHeuristicStops(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	db := randomDb(1, 10)
+	q := Query{Optimize: Damage, Evaluator: Evaluator{Shikigami: shiki}, Strategy: Anneal}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, stats, err := db.BestSetsContext(ctx, 1, q)
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, stats.Covered < 1)
+	assert.True(t, len(results) <= 1)
+}
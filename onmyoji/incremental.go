@@ -0,0 +1,133 @@
+package onmyoji
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+)
+
+// Fingerprint returns a hash of everything about a soul, which identical souls share.
+func (s Soul) Fingerprint() uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%#v", s)
+	return h.Sum64()
+}
+
+// Fingerprints returns the Fingerprint of every soul in each slot.
+func (db *SoulDb) Fingerprints() [6][]uint64 {
+	var prints [6][]uint64
+	for k, slot := range [...][]Soul{db.Slot1, db.Slot2, db.Slot3, db.Slot4, db.Slot5, db.Slot6} {
+		prints[k] = make([]uint64, len(slot))
+		for i, sl := range slot {
+			prints[k][i] = sl.Fingerprint()
+		}
+	}
+	return prints
+}
+
+// Previous describes an earlier search for the same query, so that searching a changed database
+// only needs to check the sets that include souls the earlier search didn't have.
+type Previous struct {
+	// Souls holds the Fingerprints of the database the earlier search used.
+	Souls [6][]uint64
+	// N is how many sets the earlier search looked for, and Results the sets it found, best first.
+	N       int
+	Results []Result
+}
+
+// BestSetsSince works like BestSets, but reuses an earlier search for the same query. If the
+// database still holds every soul of the earlier results, no set made only of souls the earlier
+// search had can beat them, so only sets that include a new soul are checked. Otherwise, or if the
+// earlier search looked for fewer sets or used a Strategy other than Exhaustive, it searches every
+// combination.
+func (db *SoulDb) BestSetsSince(n int, q Query, prev Previous) []Result {
+	results, _, _ := db.BestSetsSinceContext(context.Background(), n, q, prev)
+	return results
+}
+
+// BestSetsSinceContext works like BestSetsSince, but stops searching when ctx is done. It then
+// returns the best sets found so far along with the context's error.
+func (db *SoulDb) BestSetsSinceContext(ctx context.Context, n int, q Query, prev Previous) ([]Result, Stats, error) {
+	return db.bestSets(ctx, n, q, &prev)
+}
+
+// reusable returns whether the earlier results are the best n sets of db made only of souls the
+// earlier search had, which needs each of their souls to still be in db.
+func (prev *Previous) reusable(db *SoulDb, n int) bool {
+	if prev == nil || prev.N < n {
+		return false
+	}
+	prints := db.Fingerprints()
+	for _, r := range prev.Results {
+		for k, sl := range r.Souls.souls {
+			if !containsPrint(prints[k], sl.Fingerprint()) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func containsPrint(prints []uint64, p uint64) bool {
+	for _, x := range prints {
+		if x == p {
+			return true
+		}
+	}
+	return false
+}
+
+// searchSince gives the earlier results to the collector, then searches the sets of the slots that
+// include at least one soul the earlier search didn't have. Each of those sets is searched once, by
+// the slot of its first new soul.
+func (q *Query) searchSince(ctx context.Context, slots [6][]Soul, objs []*objective, rank *ranking, prev *Previous) (Stats, error) {
+	var old, added [6][]Soul
+	for k, slot := range slots {
+		seen := make(map[uint64]bool, len(prev.Souls[k]))
+		for _, p := range prev.Souls[k] {
+			seen[p] = true
+		}
+		for _, sl := range slot {
+			if seen[sl.Fingerprint()] {
+				old[k] = append(old[k], sl)
+			} else {
+				added[k] = append(added[k], sl)
+			}
+		}
+	}
+	for _, r := range prev.Results {
+		rank.add(r)
+	}
+
+	stats := Stats{Reused: true, Rejected: make([]int64, len(q.Constraints))}
+	// Weigh how much of each part was covered by how many combinations it has.
+	combinations := func(slots [6][]Soul) float64 {
+		n := 1.0
+		for _, slot := range slots {
+			n *= float64(len(slot))
+		}
+		return n
+	}
+	total, covered := combinations(slots), combinations(old)
+	var err error
+	for k := range slots {
+		stats.NewSouls += len(added[k])
+		if len(added[k]) == 0 {
+			continue
+		}
+		part := slots
+		copy(part[:k], old[:k])
+		part[k] = added[k]
+		partStats, partErr := q.search(ctx, part, objs, rank)
+		stats.add(partStats)
+		covered += partStats.Covered * combinations(part)
+		if err == nil {
+			err = partErr
+		}
+	}
+	stats.Covered = 1
+	if err != nil && total > 0 {
+		stats.Covered = covered / total
+	}
+	return stats, err
+}
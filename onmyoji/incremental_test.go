@@ -0,0 +1,78 @@
+package onmyoji
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBestSetsSinceMatchesFullSearch(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	q := Query{
+		Primaries:   []string{"Seductress", "Shadow"},
+		Optimize:    Damage,
+		Evaluator:   Evaluator{Shikigami: shiki, Options: DamageOptions{Orbs: 5}},
+		Constraints: []Constraint{{Attribute: "crit", Min: 70}},
+	}
+
+	for seed := int64(1); seed <= 4; seed++ {
+		db := randomDb(seed, 8)
+		before, stats, err := db.BestSetsContext(context.Background(), 3, q)
+		assert.NoError(t, err)
+		assert.False(t, stats.Reused)
+		prev := Previous{Souls: db.Fingerprints(), N: 3, Results: before}
+
+		// Add a few souls, and remove one that the earlier results don't use.
+		rng := rand.New(rand.NewSource(seed))
+		changed := db.Clone()
+		for i := 0; i < 3; i++ {
+			k := rng.Intn(6)
+			slot := []*[]Soul{&changed.Slot1, &changed.Slot2, &changed.Slot3, &changed.Slot4, &changed.Slot5, &changed.Slot6}[k]
+			*slot = append(*slot, randomSoul(rng, k))
+		}
+		for i, sl := range changed.Slot3 {
+			used := false
+			for _, r := range before {
+				used = used || r.Souls.souls[2] == sl
+			}
+			if !used {
+				changed.Slot3 = remove(changed.Slot3, i)
+				break
+			}
+		}
+
+		full, fullStats, err := changed.BestSetsContext(context.Background(), 3, q)
+		assert.NoError(t, err)
+		since, stats, err := changed.BestSetsSinceContext(context.Background(), 3, q, prev)
+		assert.NoError(t, err)
+		assert.Equal(t, full, since, "seed %v", seed)
+		assert.True(t, stats.Reused)
+		assert.Equal(t, 3, stats.NewSouls)
+		assert.Equal(t, 1.0, stats.Covered)
+		assert.True(t, stats.Completed < fullStats.Completed, "seed %v: %v >= %v", seed, stats.Completed, fullStats.Completed)
+
+		// Nothing new to check.
+		same, stats, err := db.BestSetsSinceContext(context.Background(), 3, q, prev)
+		assert.NoError(t, err)
+		assert.Equal(t, before, same)
+		assert.Equal(t, int64(0), stats.Completed)
+
+		// Removing a soul of the best set means searching everything again.
+		changed = db.Clone()
+		changed.Remove(before[0].Souls)
+		full, _, err = changed.BestSetsContext(context.Background(), 3, q)
+		assert.NoError(t, err)
+		since, stats, err = changed.BestSetsSinceContext(context.Background(), 3, q, prev)
+		assert.NoError(t, err)
+		assert.False(t, stats.Reused)
+		assert.Equal(t, full, since, "seed %v", seed)
+
+		// So does asking for more sets than the earlier search found.
+		_, stats, err = db.BestSetsSinceContext(context.Background(), 4, q, prev)
+		assert.NoError(t, err)
+		assert.False(t, stats.Reused)
+	}
+}
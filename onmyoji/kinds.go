@@ -0,0 +1,175 @@
+package onmyoji
+
+import (
+	"strings"
+	"sync"
+)
+
+// kind is a soul type interned to a small number, so that sets can count their types without maps
+// or string comparisons. Kind 0 is the type of souls with an empty type.
+type kind uint16
+
+// bonus is a 2-soul attribute bonus.
+type bonus uint8
+
+const (
+	noBonus bonus = iota
+	atkBonus
+	critBonus
+	hpBonus
+	defBonus
+	effectHitBonus
+)
+
+var bonusNames = [...]string{
+	noBonus:        "",
+	atkBonus:       "atk bonus",
+	critBonus:      "crit",
+	hpBonus:        "hp bonus",
+	defBonus:       "def bonus",
+	effectHitBonus: "effect hit",
+}
+
+// kindInfo describes a soul type.
+type kindInfo struct {
+	name    string
+	bonus   bonus
+	effects []SetEffect
+}
+
+// knownKinds lists the soul types in the catalog, indexed by kind. It starts with soulTypes, and
+// DefineSoulTypes adds to it. Other types are interned after them, and have no set bonus.
+var knownKinds = catalogKinds(soulTypes)
+
+func catalogKinds(types []SoulType) []kindInfo {
+	infos := []kindInfo{{}}
+	for _, t := range types {
+		info, err := t.info()
+		if err != nil {
+			panic(err)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// kinds maps each spelling of a soul type that has been interned to its kind. Types are matched
+// ignoring case.
+var kinds = struct {
+	sync.RWMutex
+	ids  map[string]kind
+	next kind
+}{ids: knownKindIds(), next: kind(len(knownKinds))}
+
+func knownKindIds() map[string]kind {
+	ids := make(map[string]kind, len(knownKinds))
+	for i, info := range knownKinds {
+		ids[info.name] = kind(i)
+	}
+	return ids
+}
+
+// lookupKind returns the kind of a soul type, if it has been interned.
+func lookupKind(name string) (kind, bool) {
+	kinds.RLock()
+	defer kinds.RUnlock()
+	if k, ok := kinds.ids[name]; ok {
+		return k, true
+	}
+	k, ok := kinds.ids[strings.ToLower(name)]
+	return k, ok
+}
+
+// internKind returns the kind of a soul type, assigning it a new kind if it hasn't been seen yet.
+func internKind(name string) kind {
+	kinds.RLock()
+	k, ok := kinds.ids[name]
+	kinds.RUnlock()
+	if ok {
+		return k
+	}
+
+	kinds.Lock()
+	defer kinds.Unlock()
+	lower := strings.ToLower(name)
+	if k, ok = kinds.ids[lower]; !ok {
+		k = kinds.next
+		kinds.next++
+		kinds.ids[lower] = k
+	}
+	// Remember this spelling too, so looking it up again doesn't need to lowercase it.
+	kinds.ids[name] = k
+	return k
+}
+
+func (k kind) info() kindInfo {
+	if int(k) < len(knownKinds) {
+		return knownKinds[k]
+	}
+	return kindInfo{}
+}
+
+// known returns whether the soul type is in the catalog.
+func (k kind) known() bool {
+	return k != 0 && k.info().name != ""
+}
+
+func containsKind(kinds []kind, k kind) bool {
+	for _, x := range kinds {
+		if x == k {
+			return true
+		}
+	}
+	return false
+}
+
+// kindCounts counts how many souls of each type a set holds. A set of 6 souls has at most 6 types.
+type kindCounts struct {
+	kinds [6]kind
+	n     [6]int8
+	len   int8
+}
+
+func (c *kindCounts) count(k kind) int {
+	for i := int8(0); i < c.len; i++ {
+		if c.kinds[i] == k {
+			return int(c.n[i])
+		}
+	}
+	return 0
+}
+
+func (c *kindCounts) add(k kind) {
+	c.set(k, c.count(k)+1)
+}
+
+// set changes how many souls of a type there are. Setting a count to zero removes the type.
+func (c *kindCounts) set(k kind, n int) {
+	for i := int8(0); i < c.len; i++ {
+		if c.kinds[i] == k {
+			if n == 0 {
+				c.len--
+				c.kinds[i], c.n[i] = c.kinds[c.len], c.n[c.len]
+				c.kinds[c.len], c.n[c.len] = 0, 0
+			} else {
+				c.n[i] = int8(n)
+			}
+			return
+		}
+	}
+	if n != 0 {
+		c.kinds[c.len], c.n[c.len] = k, int8(n)
+		c.len++
+	}
+}
+
+// pairs returns how many types give the attribute bonus and have at least 2 souls.
+func (c *kindCounts) pairs(b bonus) int {
+	n := 0
+	for i := int8(0); i < c.len; i++ {
+		if c.n[i] >= 2 && c.kinds[i].info().bonus == b {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,55 @@
+package onmyoji
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInternKind(t *testing.T) {
+	assert.Equal(t, "seductress", internKind("Seductress").info().name)
+	assert.Equal(t, internKind("Seductress"), internKind("SEDUCTRESS"))
+
+	unknown := internKind("Not A Soul")
+	assert.True(t, int(unknown) >= len(knownKinds))
+	assert.Equal(t, unknown, internKind("not a soul"))
+	assert.NotEqual(t, unknown, internKind("Another Soul"))
+	assert.Equal(t, noBonus, unknown.info().bonus)
+
+	_, err := SoulSetBonus("Not A Soul")
+	assert.Error(t, err)
+	bonus, err := SoulSetBonus("Tomb Guard")
+	assert.NoError(t, err)
+	assert.Equal(t, "crit", bonus)
+}
+
+func TestKindCounts(t *testing.T) {
+	set := NewSoulSet([6]Soul{{Type: "Shadow"}, {Type: "shadow"}, {Type: "Claws"}, {Type: "Claws"}, {Type: "Harpy"}, {Type: "Odokuro"}})
+	assert.Equal(t, 2, set.Count("SHADOW"))
+	assert.Equal(t, 0, set.Count("Seductress"))
+	assert.Equal(t, 2, set.counts.pairs(critBonus))
+	assert.Equal(t, 0, set.counts.pairs(atkBonus))
+
+	// Removing a type leaves the same counts as never adding it.
+	counts := set.counts
+	counts.set(internKind("Seductress"), 4)
+	counts.set(internKind("Seductress"), 0)
+	assert.Equal(t, set.counts, counts)
+}
+
+func TestEvaluateDoesNotAllocate(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	ev := Evaluator{Shikigami: shiki, Options: DamageOptions{Orbs: 5}}
+	db := randomDb(1, 1)
+	souls := [6]Soul{db.Slot1[0], db.Slot2[0], db.Slot3[0], db.Slot4[0], db.Slot5[0], db.Slot6[0]}
+	var kinds [6]kind
+	for i, sl := range souls {
+		kinds[i] = internKind(sl.Type)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		ev.Evaluate(newSoulSet(souls, kinds))
+	})
+	assert.Equal(t, 0.0, allocs)
+}
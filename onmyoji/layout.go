@@ -0,0 +1,173 @@
+package onmyoji
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Layout describes how the souls of a set are grouped by type, as groups joined by +, such as
+// "4xSeductress+2xShadow" or "2xShadow+2xSeductress+2x*". Each group is a number of souls of one
+// type, which can be any of several types separated by |, or * for any type. Every group is of a
+// different type, and slots the groups leave over can hold souls of any type. The layout "free"
+// allows any souls.
+type Layout string
+
+// Free is the layout that allows any souls.
+const Free Layout = "free"
+
+// layoutGroup is a group of souls of one type in a Layout.
+type layoutGroup struct {
+	count int
+	// kinds lists the types the group can be, or is empty if it can be any type.
+	kinds []kind
+}
+
+func (g layoutGroup) allows(k kind) bool {
+	return len(g.kinds) == 0 || containsKind(g.kinds, k)
+}
+
+// layout is a parsed Layout.
+type layout struct {
+	groups []layoutGroup
+	// free is how many slots the groups leave over.
+	free int
+}
+
+// Validate returns an error if the layout can't be parsed, names an unknown soul type or has more
+// than 6 souls.
+func (l Layout) Validate() error {
+	_, err := l.parse()
+	return err
+}
+
+func (l Layout) parse() (*layout, error) {
+	if strings.EqualFold(strings.TrimSpace(string(l)), string(Free)) {
+		return &layout{free: 6}, nil
+	}
+
+	parsed := layout{free: 6}
+	seen := make(map[kind]bool)
+	for _, text := range strings.Split(string(l), "+") {
+		text = strings.TrimSpace(text)
+		x := strings.IndexAny(text, "xX")
+		if x < 0 {
+			return nil, fmt.Errorf("invalid layout %q: %q must be a count and soul type, such as 4xSeductress", l, text)
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(text[:x]))
+		if err != nil || count < 1 {
+			return nil, fmt.Errorf("invalid layout %q: %q must start with a number of souls", l, text)
+		}
+		group := layoutGroup{count: count}
+		if types := strings.TrimSpace(text[x+1:]); types != "*" {
+			for _, name := range strings.Split(types, "|") {
+				name = strings.TrimSpace(name)
+				if _, err := SoulSetBonus(name); err != nil {
+					return nil, fmt.Errorf("invalid layout %q: %v", l, err)
+				}
+				k := internKind(name)
+				if seen[k] {
+					return nil, fmt.Errorf("invalid layout %q: %v is in more than one group", l, name)
+				}
+				seen[k] = true
+				group.kinds = append(group.kinds, k)
+			}
+		}
+		parsed.groups = append(parsed.groups, group)
+		parsed.free -= count
+	}
+	if parsed.free < 0 {
+		return nil, fmt.Errorf("invalid layout %q: it has %v souls, but a set only has 6", l, 6-parsed.free)
+	}
+	return &parsed, nil
+}
+
+// layout returns the parsed Layout, or nil if it is empty. It panics if the Layout isn't valid,
+// which Query.Validate checks before searching.
+func (l Layout) layout() *layout {
+	if l == "" {
+		return nil
+	}
+	parsed, err := l.parse()
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// shortfall returns the fewest souls that must be added to, or change type in, a set with the
+// counted types for it to fit the layout. A partial set of k souls can only be completed if its
+// shortfall is at most 6-k.
+func (l *layout) shortfall(counts *kindCounts) int {
+	var used [6]kind
+	return l.assign(counts, 0, used[:0])
+}
+
+// assign tries every type for group g and those after it, without reusing a type in used, and
+// returns the fewest souls those groups are short of.
+func (l *layout) assign(counts *kindCounts, g int, used []kind) int {
+	if g == len(l.groups) {
+		return 0
+	}
+	group := l.groups[g]
+	best := math.MaxInt32
+	try := func(need int, k kind, taken bool) {
+		if need < 0 {
+			need = 0
+		}
+		next := used
+		if taken {
+			next = append(used, k)
+		}
+		if short := need + l.assign(counts, g+1, next); short < best {
+			best = short
+		}
+	}
+
+	for i := int8(0); i < counts.len; i++ {
+		if k := counts.kinds[i]; group.allows(k) && !containsKind(used, k) {
+			try(group.count-int(counts.n[i]), k, true)
+		}
+	}
+	// The group could also be a type the set doesn't have yet. There are always more types for
+	// groups of any type.
+	if len(group.kinds) == 0 {
+		try(group.count, 0, false)
+	} else {
+		for _, k := range group.kinds {
+			if counts.count(k) == 0 && !containsKind(used, k) {
+				try(group.count, k, true)
+				break
+			}
+		}
+	}
+	return best
+}
+
+// allows returns whether a set fitting the layout can hold a soul of the type.
+func (l *layout) allows(k kind) bool {
+	return l.most(k) > 0
+}
+
+// most returns how many souls of a type a set fitting the layout can hold.
+func (l *layout) most(k kind) int {
+	most := 0
+	for _, g := range l.groups {
+		if g.allows(k) && g.count > most {
+			most = g.count
+		}
+	}
+	return most + l.free
+}
+
+// fours returns the types of a group of 4 or more souls that only some types can fill, one of
+// which every set fitting the layout must have at least 4 of.
+func (l *layout) fours() []kind {
+	for _, g := range l.groups {
+		if g.count >= 4 && len(g.kinds) > 0 {
+			return g.kinds
+		}
+	}
+	return nil
+}
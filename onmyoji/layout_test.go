@@ -0,0 +1,97 @@
+package onmyoji
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLayoutValidate(t *testing.T) {
+	for _, l := range []Layout{"4xSeductress+2xShadow", "2xShadow + 2xSeductress + 2x*", "4xSeductress|Shadow", "free", "6x*"} {
+		assert.NoError(t, l.Validate(), "%v", l)
+	}
+	for _, l := range []Layout{"Seductress", "4xSeductress+4xShadow", "0xShadow", "2xShadow+2xShadow", "4xNobody", ""} {
+		assert.Error(t, l.Validate(), "%v", l)
+	}
+}
+
+func TestBestSetsWithMalformedLayout(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	db := randomDb(2, 3)
+	q := Query{Layout: "4xSeductress+4xShadow", Optimize: Damage, Evaluator: Evaluator{Shikigami: shiki}}
+
+	_, _, err = db.BestSetsContext(context.Background(), 1, q)
+	assert.Equal(t, q.Layout.Validate(), err)
+	_, err = db.Estimate(1, q)
+	assert.Error(t, err)
+	_, _, err = db.ParetoFrontContext(context.Background(), []Optimizer{Damage, "spd"}, q)
+	assert.Error(t, err)
+}
+
+func TestLayoutShortfall(t *testing.T) {
+	counts := func(types ...string) *kindCounts {
+		var c kindCounts
+		for _, typ := range types {
+			c.add(internKind(typ))
+		}
+		return &c
+	}
+	fourTwo := Layout("4xSeductress+2x*").layout()
+	assert.Equal(t, 0, fourTwo.shortfall(counts("Seductress", "Seductress", "Seductress", "Seductress", "Shadow", "Shadow")))
+	assert.Equal(t, 1, fourTwo.shortfall(counts("Seductress", "Seductress", "Seductress", "Seductress", "Shadow", "Namazu")))
+	assert.Equal(t, 2, fourTwo.shortfall(counts("Seductress", "Seductress", "Seductress", "Seductress", "Seductress", "Seductress")))
+	assert.Equal(t, 4, fourTwo.shortfall(counts("Shadow", "Namazu", "Seductress")))
+
+	pairs := Layout("2xShadow+2x*").layout()
+	assert.Equal(t, 0, pairs.shortfall(counts("Shadow", "Shadow", "Shadow", "Shadow", "Namazu", "Namazu")))
+	assert.Equal(t, 4, pairs.shortfall(counts()))
+	assert.Equal(t, 0, Free.layout().shortfall(counts("Shadow", "Namazu", "Seductress", "Odokuro", "Watcher", "Harpy")))
+}
+
+func TestBestSetsWithLayout(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	ev := Evaluator{Shikigami: shiki, Options: DamageOptions{Orbs: 5}}
+	any := func(Result) bool { return true }
+
+	// Each layout is checked against a test that doesn't use the layout's code.
+	layouts := map[Layout]func(SoulSet) bool{
+		Free: func(SoulSet) bool { return true },
+		"4xSeductress|Shadow+2x*": func(set SoulSet) bool {
+			for _, primary := range []string{"Seductress", "Shadow"} {
+				if set.Count(primary) == 4 {
+					return set.counts.len == 2
+				}
+			}
+			return false
+		},
+		"2xShadow+2x*": func(set SoulSet) bool {
+			if set.Count("Shadow") < 2 {
+				return false
+			}
+			for i, n := range set.counts.n[:set.counts.len] {
+				if n >= 2 && set.counts.kinds[i] != internKind("Shadow") {
+					return true
+				}
+			}
+			return false
+		},
+	}
+
+	for seed := int64(1); seed <= 2; seed++ {
+		db := randomDb(seed, 6)
+		for _, opt := range []Optimizer{Damage, HP} {
+			for l, valid := range layouts {
+				q := Query{Layout: l, Optimize: opt, Evaluator: ev}
+				expected := exhaustiveResults(db, opt, ev, valid, any)
+				top := db.BestSets(5, q)
+				assert.Equal(t, head(values(opt, expected), 5), values(opt, top), "seed %v, %v, %v", seed, opt, l)
+				for _, r := range top {
+					assert.True(t, q.Allows(r), "%v: %v", l, r)
+				}
+			}
+		}
+	}
+}
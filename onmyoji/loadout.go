@@ -0,0 +1,113 @@
+package onmyoji
+
+import (
+	"fmt"
+	"strings"
+)
+
+// loadout returns the IDs of the souls equipped on the named shikigami, matching the name without
+// regard to case.
+func (db *SoulDb) loadout(name string) ([6]int, bool) {
+	for wearer, ids := range db.Loadouts {
+		if strings.EqualFold(wearer, name) {
+			return ids, true
+		}
+	}
+	return [6]int{}, false
+}
+
+// Loadout returns the souls equipped on the named shikigami, and whether it has a soul in every
+// slot. Slots without a soul are left empty.
+func (db *SoulDb) Loadout(name string) (SoulSet, bool) {
+	ids, ok := db.loadout(name)
+	if !ok {
+		return SoulSet{}, false
+	}
+	var souls [6]Soul
+	complete := true
+	for k, slot := range db.slots() {
+		if i := findID(*slot, ids[k]); i >= 0 {
+			souls[k] = (*slot)[i]
+		} else {
+			complete = false
+		}
+	}
+	return NewSoulSet(souls), complete
+}
+
+// Wearer returns the shikigami that the soul in slot k is equipped on, or "" if it isn't equipped.
+func (db *SoulDb) Wearer(k int, sl Soul) string {
+	if sl.ID == 0 {
+		return ""
+	}
+	for wearer, ids := range db.Loadouts {
+		if ids[k] == sl.ID {
+			return wearer
+		}
+	}
+	return ""
+}
+
+// For returns the souls that the named shikigami can use. Locked souls are reserved for the
+// shikigami they're equipped on, so they are left out unless the shikigami wears them.
+func (db *SoulDb) For(name string) SoulDb {
+	ids, _ := db.loadout(name)
+	usable := SoulDb{Loadouts: db.Loadouts, current: db.current}
+	for k, slot := range usable.slots() {
+		for _, sl := range *db.slots()[k] {
+			if !sl.Locked || (sl.ID != 0 && sl.ID == ids[k]) {
+				*slot = append(*slot, sl)
+			}
+		}
+	}
+	return usable
+}
+
+// CheckLoadouts returns an error if a loadout names a soul that isn't in its slot, or if two
+// loadouts share a soul.
+func (db *SoulDb) CheckLoadouts() error {
+	var wearers [6]map[int]string
+	for wearer, ids := range db.Loadouts {
+		for k, slot := range db.slots() {
+			if ids[k] == 0 {
+				continue
+			}
+			if findID(*slot, ids[k]) < 0 {
+				return fmt.Errorf("%v wears soul %v in slot %v, but there is no such soul", wearer, ids[k], k+1)
+			}
+			if wearers[k] == nil {
+				wearers[k] = make(map[int]string)
+			}
+			if other, ok := wearers[k][ids[k]]; ok {
+				return fmt.Errorf("%v and %v both wear soul %v", other, wearer, ids[k])
+			}
+			wearers[k][ids[k]] = wearer
+		}
+	}
+	return nil
+}
+
+// findID returns the index of the soul with the ID in a, or -1 if there isn't one.
+func findID(a []Soul, id int) int {
+	if id == 0 {
+		return -1
+	}
+	for i, sl := range a {
+		if sl.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Describe formats a result like its String method, noting which souls are already equipped and on
+// which shikigami, and which souls Projected assumes are enhanced.
+func (db *SoulDb) Describe(r Result) string {
+	return r.format(func(k int, sl Soul) string {
+		note := db.enhanceNote(sl)
+		if wearer := db.Wearer(k, sl); wearer != "" {
+			note += " (already equipped on " + wearer + ")"
+		}
+		return note
+	})
+}
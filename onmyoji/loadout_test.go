@@ -0,0 +1,55 @@
+package onmyoji
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadouts(t *testing.T) {
+	db := randomDb(1, 6)
+	db.AssignIDs()
+	// Seimei wears and locks the first soul in each slot, while Ibaraki wears the second unlocked.
+	var seimei, ibaraki [6]int
+	for k, slot := range db.slots() {
+		(*slot)[0].Locked = true
+		seimei[k], ibaraki[k] = (*slot)[0].ID, (*slot)[1].ID
+	}
+	db.Loadouts = map[string][6]int{"Seimei": seimei, "Ibaraki Doji": ibaraki}
+	assert.NoError(t, db.CheckLoadouts())
+
+	set, complete := db.Loadout("seimei")
+	assert.True(t, complete)
+	assert.Equal(t, seimei, set.IDs())
+	assert.Equal(t, "Ibaraki Doji", db.Wearer(2, db.Slot3[1]))
+	assert.Equal(t, "", db.Wearer(2, db.Slot3[2]))
+
+	// Only Seimei can use the locked souls.
+	assert.Len(t, db.For("Seimei").Slot1, 6)
+	others := db.For("Ibaraki Doji")
+	assert.Len(t, others.Slot1, 5)
+	for _, sl := range others.Slot1 {
+		assert.False(t, sl.Locked)
+	}
+
+	shiki, err := GetShikigami("Ibaraki Doji")
+	assert.NoError(t, err)
+	q := Query{Optimize: Damage, Evaluator: Evaluator{Shikigami: shiki}}
+	plan, err := db.GreedyTeam([]Member{{Name: "Ibaraki Doji", Query: q, Weight: 1}})
+	assert.NoError(t, err)
+	for k, id := range plan.Results[0].Souls.IDs() {
+		assert.NotEqual(t, seimei[k], id)
+	}
+	assert.True(t, q.Allows(plan.Results[0]))
+	q.Primaries = []string{"Shadow"}
+	assert.Equal(t, plan.Results[0].Souls.Count("Shadow") >= 4, q.Allows(plan.Results[0]))
+
+	described := db.Describe(q.Evaluator.Evaluate(set))
+	assert.Equal(t, 6, strings.Count(described, "(already equipped on Seimei)"))
+
+	db.Loadouts["Ubume"] = seimei
+	assert.Error(t, db.CheckLoadouts())
+	db.Loadouts["Ubume"] = [6]int{1000}
+	assert.Error(t, db.CheckLoadouts())
+}
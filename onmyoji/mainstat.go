@@ -0,0 +1,159 @@
+package onmyoji
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Stat is one stat of a soul, such as a main stat of 57 speed.
+type Stat struct {
+	// Name names the stat the way souls files do, such as "spd" or "critdmg".
+	Name  string
+	Value int
+}
+
+// statNames names the stats of a soul, in the order of soulStats.
+var statNames = [len(soulStats)]string{"atk", "atkbonus", "crit", "critdmg", "spd", "hp", "hpbonus", "def", "defbonus", "effecthit", "effectres"}
+
+// stats returns pointers to the soul's stats, in the order of soulStats.
+func (s *Soul) stats() [len(soulStats)]*int {
+	return [...]*int{&s.Atk, &s.AtkBonus, &s.Crit, &s.CritDmg, &s.Spd, &s.HP, &s.HPBonus, &s.Def, &s.DefBonus, &s.EffectHit, &s.EffectRes}
+}
+
+// stat returns a pointer to the soul's stat with the name, or nil if souls have no such stat.
+func (s *Soul) stat(name string) *int {
+	if i := statIndex(name); i >= 0 {
+		return s.stats()[i]
+	}
+	return nil
+}
+
+// Substats returns the soul's stats other than its main stat, leaving out stats it doesn't have.
+// If the soul doesn't record its main stat, they are all of its stats.
+func (s Soul) Substats() []Stat {
+	if p := s.stat(s.Main.Name); p != nil {
+		*p -= s.Main.Value
+	}
+	var subs []Stat
+	for i, p := range s.stats() {
+		if *p != 0 {
+			subs = append(subs, Stat{statNames[i], *p})
+		}
+	}
+	return subs
+}
+
+// slotMainStats lists the main stats that souls can have in each slot. Souls in slots 1, 3 and 5
+// always have the same main stat.
+var slotMainStats = [6][]string{
+	{"atk"},
+	{"atkbonus", "defbonus", "hpbonus", "spd"},
+	{"def"},
+	{"atkbonus", "defbonus", "hpbonus", "effecthit", "effectres"},
+	{"hp"},
+	{"atkbonus", "defbonus", "hpbonus", "crit", "critdmg"},
+}
+
+// MainStats returns the main stats that souls in a slot, from 1 to 6, can have.
+func MainStats(slot int) []string {
+	if slot < 1 || slot > 6 {
+		return nil
+	}
+	return append([]string(nil), slotMainStats[slot-1]...)
+}
+
+// CheckMainStat returns an error if souls in a slot, from 1 to 6, can't have the main stat.
+func CheckMainStat(slot int, name string) error {
+	if slot < 1 || slot > 6 {
+		return fmt.Errorf("there is no slot %v", slot)
+	}
+	for _, n := range slotMainStats[slot-1] {
+		if strings.EqualFold(name, n) {
+			return nil
+		}
+	}
+	return fmt.Errorf("slot %v can't have a %q main stat, must be one of %q", slot, name, slotMainStats[slot-1])
+}
+
+// CheckMainStats returns an error if a soul has a main stat that its slot can't have.
+func (db *SoulDb) CheckMainStats() error {
+	for k, slot := range db.slots() {
+		for _, sl := range *slot {
+			if sl.Main.Name == "" {
+				continue
+			}
+			if err := CheckMainStat(k+1, sl.Main.Name); err != nil {
+				return fmt.Errorf("soul %v: %v", sl, err)
+			}
+		}
+	}
+	return nil
+}
+
+// hasMain returns whether the soul's main stat is the named stat.
+func (s Soul) hasMain(name string) bool {
+	return s.Main.Name != "" && strings.EqualFold(s.Main.Name, name)
+}
+
+// plainSoul has the fields of a Soul without its YAML methods.
+type plainSoul Soul
+
+// soulYAML is a soul as written in YAML. A soul can list its main stat and substats apart, such as
+// main: {spd: 57} and subs: {crit: 8, atk: 20}, or only list its stats without saying which is its
+// main stat.
+type soulYAML struct {
+	plainSoul `yaml:",inline"`
+	Main      map[string]int `yaml:",omitempty"`
+	Subs      map[string]int `yaml:",omitempty"`
+}
+
+// UnmarshalYAML reads a soul, adding up its main stat and substats if it lists them apart.
+func (s *Soul) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var y soulYAML
+	if err := unmarshal(&y); err != nil {
+		return err
+	}
+	*s = Soul(y.plainSoul)
+	if len(y.Main) == 0 && len(y.Subs) == 0 {
+		return nil
+	}
+
+	for _, p := range s.stats() {
+		if *p != 0 {
+			return fmt.Errorf("soul %v: list stats under main and subs, or without them, but not both", s)
+		}
+	}
+	if len(y.Main) > 1 {
+		return fmt.Errorf("soul %v: only one main stat is allowed", s)
+	}
+	for name, v := range y.Main {
+		s.Main = Stat{strings.ToLower(name), v}
+	}
+	for _, stats := range []map[string]int{y.Main, y.Subs} {
+		for name, v := range stats {
+			p := s.stat(name)
+			if p == nil {
+				return fmt.Errorf("soul %v: unknown stat %q, must be one of %q", s, name, statNames)
+			}
+			*p += v
+		}
+	}
+	return nil
+}
+
+// MarshalYAML writes a soul the same way UnmarshalYAML reads it, listing its main stat apart if
+// the soul records it.
+func (s Soul) MarshalYAML() (interface{}, error) {
+	if s.Main.Name == "" {
+		return plainSoul(s), nil
+	}
+	y := soulYAML{Main: map[string]int{s.Main.Name: s.Main.Value}, Subs: make(map[string]int)}
+	for _, sub := range s.Substats() {
+		y.Subs[sub.Name] = sub.Value
+	}
+	for _, p := range s.stats() {
+		*p = 0
+	}
+	y.plainSoul = plainSoul(s)
+	return y, nil
+}
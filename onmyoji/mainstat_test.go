@@ -0,0 +1,95 @@
+package onmyoji
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSoulYAML(t *testing.T) {
+	var sl Soul
+	assert.NoError(t, yaml.Unmarshal([]byte("{id: 3, type: Seductress, main: {spd: 57}, subs: {spd: 3, crit: 8}}"), &sl))
+	assert.Equal(t, Soul{ID: 3, Type: "Seductress", Spd: 60, Crit: 8, Main: Stat{"spd", 57}}, sl)
+	assert.Equal(t, []Stat{{"crit", 8}, {"spd", 3}}, sl.Substats())
+	assert.Contains(t, sl.String(), "Main=spd")
+
+	// Souls that don't say which stat is their main stat keep working.
+	var plain Soul
+	assert.NoError(t, yaml.Unmarshal([]byte("{type: Shadow, atk: 486, crit: 3}"), &plain))
+	assert.Equal(t, Soul{Type: "Shadow", Atk: 486, Crit: 3}, plain)
+	assert.Equal(t, []Stat{{"atk", 486}, {"crit", 3}}, plain.Substats())
+
+	// Writing souls reads back the same souls.
+	for _, want := range []Soul{sl, plain} {
+		out, err := yaml.Marshal(want)
+		assert.NoError(t, err)
+		var got Soul
+		assert.NoError(t, yaml.Unmarshal(out, &got))
+		assert.Equal(t, want, got, "%s", out)
+	}
+
+	for _, bad := range []string{
+		"{type: Shadow, atk: 486, main: {atk: 486}}",
+		"{type: Shadow, main: {atk: 486, crit: 3}}",
+		"{type: Shadow, main: {luck: 7}}",
+		"{type: Shadow, main: {atk: 486}, subs: {luck: 7}}",
+	} {
+		assert.Error(t, yaml.Unmarshal([]byte(bad), &sl), bad)
+	}
+}
+
+func TestCheckMainStats(t *testing.T) {
+	assert.NoError(t, CheckMainStat(2, "SPD"))
+	assert.NoError(t, CheckMainStat(6, "critdmg"))
+	assert.NoError(t, CheckMainStat(1, "atk"))
+	assert.Error(t, CheckMainStat(2, "crit"))
+	assert.Error(t, CheckMainStat(3, "atkbonus"))
+	assert.Error(t, CheckMainStat(7, "spd"))
+	assert.Equal(t, []string{"hp"}, MainStats(5))
+
+	db := SoulDb{Slot2: []Soul{{Type: "Shadow", Spd: 57, Main: Stat{"spd", 57}}}}
+	assert.NoError(t, db.CheckMainStats())
+	db.Slot4 = []Soul{{ID: 9, Type: "Shadow", Spd: 57, Main: Stat{"spd", 57}}}
+	assert.Error(t, db.CheckMainStats())
+}
+
+func TestBestSetsWithMainStats(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	ev := Evaluator{Shikigami: shiki, Options: DamageOptions{Orbs: 5}}
+	any := func(Result) bool { return true }
+
+	// Give the random souls main stats, guessing them from their largest stats.
+	db := randomDb(1, 6)
+	for k, slot := range db.slots() {
+		for i := range *slot {
+			sl := &(*slot)[i]
+			best := 0
+			for _, name := range slotMainStats[k] {
+				if v := *sl.stat(name); v > best {
+					sl.Main, best = Stat{name, v}, v
+				}
+			}
+		}
+	}
+	mainStats := [6]string{1: "spd", 5: "critdmg"}
+	valid := func(set SoulSet) bool {
+		return withPairs(set) && set.souls[1].Main.Name == "spd" && set.souls[5].Main.Name == "critdmg"
+	}
+
+	q := Query{MainStats: mainStats, Optimize: Damage, Evaluator: ev}
+	expected := exhaustiveResults(db, Damage, ev, valid, any)
+	assert.NotEmpty(t, expected)
+	top := db.BestSets(5, q)
+	assert.Equal(t, head(values(Damage, expected), 5), values(Damage, top))
+	for _, r := range top {
+		assert.True(t, q.Allows(r), "%v", r)
+	}
+
+	// The best set without the requirement doesn't meet it.
+	free := db.BestSet(Query{Optimize: Damage, Evaluator: ev})
+	if !valid(free.Souls) {
+		assert.False(t, q.Allows(free))
+	}
+}
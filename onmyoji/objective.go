@@ -0,0 +1,675 @@
+package onmyoji
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// field identifies an attribute of a Result that can be optimized.
+type field int
+
+const (
+	damageField field = iota
+	healField
+	hpField
+	critField
+	spdField
+	atkField
+	critDmgField
+	defField
+	effectHitField
+	effectResField
+	numFields
+)
+
+// fieldNames maps the names that can be used in an Optimizer expression to result fields.
+var fieldNames = map[string]field{
+	"damage":    damageField,
+	"dmg":       damageField,
+	"heal":      healField,
+	"hp":        hpField,
+	"crit":      critField,
+	"spd":       spdField,
+	"speed":     spdField,
+	"atk":       atkField,
+	"critdmg":   critDmgField,
+	"def":       defField,
+	"effecthit": effectHitField,
+	"effectres": effectResField,
+}
+
+// of returns the field's value in a result.
+func (f field) of(r Result) int {
+	switch f {
+	case damageField:
+		return r.Damage
+	case healField:
+		return r.Heal
+	case hpField:
+		return r.HP
+	case critField:
+		return r.Crit
+	case spdField:
+		return r.Spd
+	case atkField:
+		return r.Atk
+	case critDmgField:
+		return r.CritDmg
+	case defField:
+		return r.Def
+	case effectHitField:
+		return r.EffectHit
+	case effectResField:
+		return r.EffectRes
+	}
+	panic("unknown field")
+}
+
+// compute calculates only the field for a shikigami wearing the soul set.
+func (f field) compute(ev Evaluator, set SoulSet) int {
+	switch f {
+	case damageField:
+		return set.Damage(ev.Shikigami, ev.Modifiers, ev.Options)
+	case healField:
+		return set.Heal(ev.Shikigami, ev.Modifiers)
+	case hpField:
+		return set.HP(ev.Shikigami, ev.Modifiers)
+	case critField:
+		return set.ComputeCrit(ev.Shikigami, ev.Modifiers.Crit)
+	case spdField:
+		return ev.Shikigami.Spd + set.total.Spd
+	case atkField:
+		return set.ComputeAtk(ev.Shikigami, ev.Modifiers)
+	case critDmgField:
+		return set.ComputeCritDmg(ev.Shikigami, ev.Modifiers.CritDmg)
+	case defField:
+		return set.ComputeDef(ev.Shikigami, ev.Modifiers)
+	case effectHitField:
+		return set.ComputeEffectHit(ev.Shikigami, ev.Modifiers.EffectHit)
+	case effectResField:
+		return set.ComputeEffectRes(ev.Shikigami, ev.Modifiers.EffectRes)
+	}
+	panic("unknown field")
+}
+
+// affectedBy returns whether owning 2 or 4 souls of a type can change the field.
+func (f field) affectedBy(k kind) bool {
+	switch k.info().bonus {
+	case critBonus:
+		if f == damageField || f == healField || f == critField {
+			return true
+		}
+	case atkBonus:
+		if f == damageField || f == atkField {
+			return true
+		}
+	case hpBonus:
+		if f == hpField || f == healField {
+			return true
+		}
+	case defBonus:
+		if f == defField {
+			return true
+		}
+	case effectHitBonus:
+		if f == effectHitField {
+			return true
+		}
+	}
+	return f.pieces(k) > 0
+}
+
+// soulStats lists the stats of a soul and the fields that never decrease as that stat increases.
+var soulStats = [...]struct {
+	of     func(Soul) int
+	fields []field
+}{
+	{func(s Soul) int { return s.Atk }, []field{damageField, atkField}},
+	{func(s Soul) int { return s.AtkBonus }, []field{damageField, atkField}},
+	{func(s Soul) int { return s.Crit }, []field{damageField, healField, critField}},
+	{func(s Soul) int { return s.CritDmg }, []field{damageField, healField, critDmgField}},
+	{func(s Soul) int { return s.Spd }, []field{spdField}},
+	{func(s Soul) int { return s.HP }, []field{hpField, healField}},
+	{func(s Soul) int { return s.HPBonus }, []field{hpField, healField}},
+	{func(s Soul) int { return s.Def }, []field{defField}},
+	{func(s Soul) int { return s.DefBonus }, []field{defField}},
+	{func(s Soul) int { return s.EffectHit }, []field{effectHitField}},
+	{func(s Soul) int { return s.EffectRes }, []field{effectResField}},
+}
+
+// fieldValues holds a number for each field.
+type fieldValues [numFields]float64
+
+// fieldSet marks a group of fields.
+type fieldSet [numFields]bool
+
+// compute calculates only the fields in the set for a shikigami wearing the soul set.
+func (fs *fieldSet) compute(ev Evaluator, set SoulSet) fieldValues {
+	var v fieldValues
+	for f := field(0); f < numFields; f++ {
+		if fs[f] {
+			v[f] = float64(f.compute(ev, set))
+		}
+	}
+	return v
+}
+
+// affects returns whether owning 2 or 4 souls of a type can change any field in the set.
+func (fs *fieldSet) affects(k kind) bool {
+	for f := field(0); f < numFields; f++ {
+		if fs[f] && f.affectedBy(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// pieces returns the fewest souls of a type that give a set effect changing any field in the set,
+// or 0 if no number of souls does.
+func (fs *fieldSet) pieces(k kind) int {
+	fewest := 0
+	for f := field(0); f < numFields; f++ {
+		if n := f.pieces(k); fs[f] && n > 0 && (fewest == 0 || n < fewest) {
+			fewest = n
+		}
+	}
+	return fewest
+}
+
+// expr is a parsed arithmetic expression over result fields.
+type expr interface {
+	eval(v *fieldValues) float64
+	// interval returns the range of the expression when each field lies within [lo, hi].
+	interval(lo, hi *fieldValues) (float64, float64)
+	// monotone returns whether the expression never decreases (inc) or never increases (dec) as
+	// field f increases, assuming all fields are non-negative.
+	monotone(f field) (inc, dec bool)
+	// constant returns the expression's value if it doesn't depend on any field.
+	constant() (float64, bool)
+	nonNegative() bool
+}
+
+type number float64
+
+func (n number) eval(*fieldValues) float64                     { return float64(n) }
+func (n number) interval(_, _ *fieldValues) (float64, float64) { return float64(n), float64(n) }
+func (n number) monotone(field) (bool, bool)                   { return true, true }
+func (n number) constant() (float64, bool)                     { return float64(n), true }
+func (n number) nonNegative() bool                             { return n >= 0 }
+
+type variable field
+
+func (v variable) eval(vals *fieldValues) float64                  { return vals[v] }
+func (v variable) interval(lo, hi *fieldValues) (float64, float64) { return lo[v], hi[v] }
+func (v variable) monotone(f field) (bool, bool)                   { return true, field(v) != f }
+func (v variable) constant() (float64, bool)                       { return 0, false }
+func (v variable) nonNegative() bool                               { return true }
+
+type negate struct{ x expr }
+
+func (n negate) eval(v *fieldValues) float64 { return -n.x.eval(v) }
+func (n negate) interval(lo, hi *fieldValues) (float64, float64) {
+	l, h := n.x.interval(lo, hi)
+	return -h, -l
+}
+func (n negate) monotone(f field) (bool, bool) {
+	inc, dec := n.x.monotone(f)
+	return dec, inc
+}
+func (n negate) constant() (float64, bool) {
+	c, ok := n.x.constant()
+	return -c, ok
+}
+func (n negate) nonNegative() bool {
+	c, ok := n.constant()
+	return ok && c >= 0
+}
+
+type binary struct {
+	op   byte
+	l, r expr
+}
+
+func (b binary) eval(v *fieldValues) float64 {
+	l, r := b.l.eval(v), b.r.eval(v)
+	switch b.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	}
+	return l / r
+}
+
+func (b binary) interval(lo, hi *fieldValues) (float64, float64) {
+	ll, lh := b.l.interval(lo, hi)
+	rl, rh := b.r.interval(lo, hi)
+	switch b.op {
+	case '+':
+		return ll + rl, lh + rh
+	case '-':
+		return ll - rh, lh - rl
+	case '/':
+		if rl <= 0 && rh >= 0 {
+			return math.Inf(-1), math.Inf(1)
+		}
+		rl, rh = 1/rh, 1/rl
+	}
+	products := [...]float64{ll * rl, ll * rh, lh * rl, lh * rh}
+	min, max := products[0], products[0]
+	for _, p := range products[1:] {
+		min, max = math.Min(min, p), math.Max(max, p)
+	}
+	return min, max
+}
+
+func (b binary) monotone(f field) (bool, bool) {
+	linc, ldec := b.l.monotone(f)
+	rinc, rdec := b.r.monotone(f)
+	switch b.op {
+	case '+':
+		return linc && rinc, ldec && rdec
+	case '-':
+		return linc && rdec, ldec && rinc
+	case '*':
+		if c, ok := b.l.constant(); ok {
+			return scaled(c, rinc, rdec)
+		}
+		if c, ok := b.r.constant(); ok {
+			return scaled(c, linc, ldec)
+		}
+		if b.l.nonNegative() && b.r.nonNegative() {
+			return linc && rinc, ldec && rdec
+		}
+	case '/':
+		if c, ok := b.r.constant(); ok {
+			return scaled(c, linc, ldec)
+		}
+	}
+	return false, false
+}
+
+func scaled(c float64, inc, dec bool) (bool, bool) {
+	if c < 0 {
+		return dec, inc
+	}
+	return inc, dec
+}
+
+func (b binary) constant() (float64, bool) {
+	_, lok := b.l.constant()
+	_, rok := b.r.constant()
+	if !lok || !rok {
+		return 0, false
+	}
+	c := b.eval(&fieldValues{})
+	return c, !math.IsNaN(c) && !math.IsInf(c, 0)
+}
+
+func (b binary) nonNegative() bool {
+	if c, ok := b.constant(); ok {
+		return c >= 0
+	}
+	return b.op != '-' && b.l.nonNegative() && b.r.nonNegative()
+}
+
+// parser reads an Optimizer expression with the usual precedence of +, -, * and /.
+type parser struct {
+	src  string
+	pos  int
+	uses fieldSet
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid optimize expression %q at position %v: %v", p.src, p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek returns the next character without consuming it, or 0 at the end of the expression.
+func (p *parser) peek() byte {
+	p.skipSpace()
+	if p.pos == len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) sum() (expr, error) {
+	x, err := p.product()
+	for err == nil && (p.peek() == '+' || p.peek() == '-') {
+		op := p.src[p.pos]
+		p.pos++
+		var y expr
+		if y, err = p.product(); err == nil {
+			x = binary{op, x, y}
+		}
+	}
+	return x, err
+}
+
+func (p *parser) product() (expr, error) {
+	x, err := p.unary()
+	for err == nil && (p.peek() == '*' || p.peek() == '/') {
+		op := p.src[p.pos]
+		p.pos++
+		var y expr
+		if y, err = p.unary(); err == nil {
+			x = binary{op, x, y}
+		}
+	}
+	return x, err
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.peek() == '-' {
+		p.pos++
+		x, err := p.unary()
+		return negate{x}, err
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		x, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+		return x, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] == '.' || (p.src[p.pos] >= '0' && p.src[p.pos] <= '9')) {
+			p.pos++
+		}
+		text := p.src[start:p.pos]
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("%v is not a number", text)
+		}
+		return number(n), nil
+	case unicode.IsLetter(rune(c)):
+		start := p.pos
+		for p.pos < len(p.src) && (unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '_') {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		f, ok := fieldNames[strings.ToLower(name)]
+		if !ok {
+			p.pos = start
+			return nil, p.errorf("unknown attribute %v, must be one of %v", name, strings.Join(knownFieldNames(), ", "))
+		}
+		p.uses[f] = true
+		return variable(f), nil
+	case c == 0:
+		return nil, p.errorf("unexpected end")
+	}
+	return nil, p.errorf("unexpected %q", c)
+}
+
+// Attribute returns a function that reads the named attribute of a result. It accepts the same
+// names as an Optimizer expression.
+func Attribute(name string) (func(Result) int, error) {
+	f, ok := fieldNames[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown attribute %v, must be one of %v", name, strings.Join(knownFieldNames(), ", "))
+	}
+	return f.of, nil
+}
+
+func knownFieldNames() []string {
+	names := make([]string, 0, len(fieldNames))
+	for name := range fieldNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// objective is a parsed Optimizer.
+type objective struct {
+	expr expr
+	uses fieldSet
+	// increasing is true if the objective never decreases when any field increases.
+	increasing bool
+	// prefer holds, for each of soulStats, 1 if a higher stat is never worse, -1 if a lower stat is
+	// never worse, 0 if the stat doesn't matter and 2 if either could be better.
+	prefer [len(soulStats)]int
+}
+
+func parseObjective(src string) (*objective, error) {
+	p := parser{src: src}
+	x, err := p.sum()
+	if err == nil && p.peek() != 0 {
+		err = p.errorf("unexpected %q", p.peek())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	obj := objective{expr: x, uses: p.uses, increasing: true}
+	var inc, dec [numFields]bool
+	for f := field(0); f < numFields; f++ {
+		inc[f], dec[f] = x.monotone(f)
+		obj.increasing = obj.increasing && inc[f]
+	}
+	for i, stat := range soulStats {
+		higher, lower, used := true, true, false
+		for _, f := range stat.fields {
+			if obj.uses[f] {
+				higher, lower, used = higher && inc[f], lower && dec[f], true
+			}
+		}
+		switch {
+		case !used:
+			obj.prefer[i] = 0
+		case higher:
+			obj.prefer[i] = 1
+		case lower:
+			obj.prefer[i] = -1
+		default:
+			obj.prefer[i] = 2
+		}
+	}
+	return &obj, nil
+}
+
+var objectives sync.Map
+
+// objective returns the parsed Optimizer. It panics if the Optimizer isn't valid, which the
+// searches check first.
+func (o Optimizer) objective() *objective {
+	obj, err := o.parsed()
+	if err != nil {
+		panic(err)
+	}
+	return obj
+}
+
+// parsed returns the parsed Optimizer, remembering it for later calls.
+func (o Optimizer) parsed() (*objective, error) {
+	if obj, ok := objectives.Load(o); ok {
+		return obj.(*objective), nil
+	}
+	obj, err := parseObjective(string(o))
+	if err != nil {
+		return nil, err
+	}
+	objectives.Store(o, obj)
+	return obj, nil
+}
+
+// Validate returns an error if the Optimizer isn't one of Damage, HP or Heal, or an arithmetic
+// expression over dmg, heal, hp, crit, spd, atk, critdmg, def, effecthit and effectres such as "0.7*dmg + 0.3*hp + 50*spd".
+func (o Optimizer) Validate() error {
+	_, err := o.parsed()
+	return err
+}
+
+// Value returns the value the Optimizer maximizes for a result, or NaN if the Optimizer isn't
+// valid.
+func (o Optimizer) Value(r Result) float64 {
+	obj, err := o.parsed()
+	if err != nil {
+		return math.NaN()
+	}
+	return obj.value(r)
+}
+
+func (obj *objective) value(r Result) float64 {
+	var v fieldValues
+	for f := field(0); f < numFields; f++ {
+		if obj.uses[f] {
+			v[f] = float64(f.of(r))
+		}
+	}
+	return obj.expr.eval(&v)
+}
+
+// evaluate calculates the objective for a shikigami wearing the soul set.
+func (obj *objective) evaluate(ev Evaluator, set SoulSet) float64 {
+	v := obj.uses.compute(ev, set)
+	return obj.expr.eval(&v)
+}
+
+// upper returns the highest the objective can be when each field lies within [lo, hi].
+func (obj *objective) upper(lo, hi *fieldValues) float64 {
+	if obj.increasing {
+		return obj.expr.eval(hi)
+	}
+	_, max := obj.expr.interval(lo, hi)
+	return max
+}
+
+// dominance holds, for each of soulStats, which way the stat can move without making a set worse,
+// using the same values as objective.prefer.
+type dominance [len(soulStats)]int
+
+// newDominance combines what the objectives, the query's constraints and the tie-breaks prefer of
+// each stat. Constraints with a minimum prefer higher stats, those with a maximum prefer lower stats
+// and those with both, such as a speed window, need the stat to stay the same.
+func newDominance(objs []*objective, q *Query) dominance {
+	var d dominance
+	for _, obj := range objs {
+		for i, p := range obj.prefer {
+			d[i] = combinePrefer(d[i], p)
+		}
+	}
+	for _, c := range q.Constraints {
+		f := c.field()
+		// Crit never goes above 100, so a maximum of 100 or more doesn't limit it.
+		hasMax := c.Max != 0 && !(f == critField && c.Max >= 100)
+		p := 0
+		switch {
+		case c.Min > 0 && hasMax:
+			p = 2
+		case c.Min > 0:
+			p = 1
+		case hasMax:
+			p = -1
+		}
+		for i, stat := range soulStats {
+			for _, sf := range stat.fields {
+				if sf == f {
+					d[i] = combinePrefer(d[i], p)
+				}
+			}
+		}
+	}
+	if q.Accept != nil || q.TieBreak != nil {
+		// Accept might reject sets, and TieBreak might order them, by any attribute, so stats nothing
+		// else cares about must match.
+		for i := range d {
+			if d[i] == 0 {
+				d[i] = 2
+			}
+		}
+	}
+	// Sets with the same value are ordered by defaultTieBreak, which prefers higher HP, damage,
+	// heal, speed and crit.
+	for i, stat := range soulStats {
+		for _, sf := range stat.fields {
+			switch sf {
+			case hpField, damageField, healField, spdField, critField:
+				d[i] = combinePrefer(d[i], 1)
+			}
+		}
+	}
+	return d
+}
+
+func combinePrefer(a, b int) int {
+	switch {
+	case a == 0:
+		return b
+	case b == 0 || a == b:
+		return a
+	}
+	return 2
+}
+
+// atLeast returns whether s1 is at least as good as s2 in every stat.
+func (d *dominance) atLeast(s1, s2 Soul) bool {
+	for i, stat := range soulStats {
+		a, b := stat.of(s1), stat.of(s2)
+		switch d[i] {
+		case 1:
+			if a < b {
+				return false
+			}
+		case -1:
+			if a > b {
+				return false
+			}
+		case 2:
+			if a != b {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// keepTop removes souls when at least n other souls of the same type are at least as good in every
+// stat and come first when comparing souls. Any set using such a soul is ordered after the sets that
+// swap in each of the others, so it isn't needed to find the n best sets.
+func (d *dominance) keepTop(souls []Soul, n int) []Soul {
+	result := make([]Soul, 0, len(souls))
+	for i, soul := range souls {
+		better := 0
+		for j, alt := range souls {
+			if i == j || alt.Type != soul.Type || !d.atLeast(alt, soul) {
+				continue
+			}
+			// Swapping in the other soul must also win the final tie-break by souls, so that the
+			// same sets are found however many are asked for. Identical souls only count against
+			// the later one.
+			if cmp := compareSouls(alt, soul); cmp > 0 || cmp == 0 && j < i {
+				better++
+			}
+		}
+		if better < n {
+			result = append(result, soul)
+		}
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+package onmyoji
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptimizerValue(t *testing.T) {
+	r := Result{Damage: 1000, Heal: 200, HP: 3000, Crit: 90, Spd: 120}
+	for opt, expected := range map[Optimizer]float64{
+		Damage:                      1000,
+		HP:                          3000,
+		Heal:                        200,
+		"0.7*dmg + 0.3*hp + 50*spd": 700 + 900 + 6000,
+		"Speed - 2 * (crit - 80)":   100,
+		"-hp / 3 + DAMAGE":          0,
+	} {
+		assert.NoError(t, opt.Validate(), "%v", opt)
+		assert.Equal(t, expected, opt.Value(r), "%v", opt)
+	}
+}
+
+func TestOptimizerValidate(t *testing.T) {
+	for opt, msg := range map[Optimizer]string{
+		"":            "unexpected end",
+		"dmg +":       "unexpected end",
+		"luck * 2":    "unknown attribute luck, must be one of atk, crit, critdmg, damage, def, dmg, effecthit, effectres, heal, hp, spd, speed",
+		"(hp":         "expected )",
+		"hp spd":      "unexpected 's'",
+		"1.2.3 * dmg": "1.2.3 is not a number",
+	} {
+		assert.Contains(t, opt.Validate().Error(), msg, "%v", opt)
+	}
+}
+
+func TestDominance(t *testing.T) {
+	strong := Soul{Type: "Shadow", Atk: 100, AtkBonus: 10, Crit: 10, CritDmg: 10, Spd: 5, HP: 500, HPBonus: 10}
+	weak := Soul{Type: "Shadow", Atk: 50, AtkBonus: 5, Crit: 5, CritDmg: 5, HP: 200, HPBonus: 5}
+	objs := func(opts ...Optimizer) []*objective {
+		var objs []*objective
+		for _, opt := range opts {
+			objs = append(objs, opt.objective())
+		}
+		return objs
+	}
+
+	// Speed doesn't matter to damage, but the default tie-break prefers it.
+	d := newDominance(objs(Damage), &Query{})
+	assert.True(t, d.atLeast(strong, weak))
+	assert.False(t, d.atLeast(weak, strong))
+
+	d = newDominance(objs(Damage), &Query{Constraints: []Constraint{{Attribute: "spd", Min: 120}}})
+	assert.True(t, d.atLeast(strong, weak))
+	d = newDominance(objs(Damage), &Query{Constraints: []Constraint{{Attribute: "spd", Min: 120, Max: 130}}})
+	assert.False(t, d.atLeast(strong, weak))
+	d = newDominance(objs(Damage), &Query{Constraints: []Constraint{{Attribute: "hp", Max: 12000}}})
+	assert.False(t, d.atLeast(strong, weak))
+	d = newDominance(objs(Damage), &Query{Accept: func(Result) bool { return true }})
+	assert.False(t, d.atLeast(strong, weak))
+
+	// Crit raises heal but lowers the objective through crit, so neither soul is better.
+	d = newDominance(objs("heal - 50*crit"), &Query{})
+	assert.False(t, d.atLeast(strong, weak))
+	assert.False(t, d.atLeast(weak, strong))
+	// The default tie-break prefers more HP, so HP must match when the objective prefers less.
+	d = newDominance(objs("-hp"), &Query{})
+	assert.False(t, d.atLeast(weak, strong))
+	assert.True(t, d.atLeast(weak, Soul{Type: "Shadow", HP: 200, HPBonus: 5}))
+	d = newDominance(objs("hp", "-hp"), &Query{})
+	assert.False(t, d.atLeast(weak, strong))
+}
+
+func TestKeepTop(t *testing.T) {
+	a := Soul{Type: "Shadow", Atk: 100}
+	b := Soul{Type: "Shadow", Atk: 50}
+	c := Soul{Type: "Shadow", Atk: 100}
+	other := Soul{Type: "Claws", Atk: 10}
+	d := newDominance([]*objective{Damage.objective()}, &Query{})
+
+	souls := []Soul{b, a, other, c}
+	assert.Equal(t, []Soul{a, other}, d.keepTop(souls, 1))
+	assert.Equal(t, []Soul{a, other, c}, d.keepTop(souls, 2))
+	assert.Equal(t, souls, d.keepTop(souls, 3))
+}
@@ -0,0 +1,153 @@
+package onmyoji
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// point is a result along with its value on each axis of a Pareto front.
+type point struct {
+	values []float64
+	result Result
+}
+
+// dominates returns whether a is at least as good as b on every axis, and better on at least one.
+func dominates(a, b []float64) bool {
+	better := false
+	for i := range a {
+		if a[i] < b[i] {
+			return false
+		}
+		better = better || a[i] > b[i]
+	}
+	return better
+}
+
+func equalValues(a, b []float64) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// front keeps the acceptable results found by any searcher that no other result dominates.
+type front struct {
+	axes []*objective
+	// compare picks between results with the same value on every axis.
+	compare Comparator
+
+	mu     sync.Mutex
+	points []point
+}
+
+// dominated returns whether a result already on the front dominates values.
+func (f *front) dominated(values []float64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range f.points {
+		if dominates(p.values, values) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *front) admits(lo, hi *fieldValues) bool {
+	upper := make([]float64, len(f.axes))
+	for i, obj := range f.axes {
+		upper[i] = obj.upper(lo, hi)
+	}
+	// Sets that only tie a result on the front might still win on a tie break.
+	return !f.dominated(upper)
+}
+
+func (f *front) collect(q *Query, set SoulSet, stats *Stats) {
+	values := make([]float64, len(f.axes))
+	for i, obj := range f.axes {
+		values[i] = obj.evaluate(q.Evaluator, set)
+	}
+	if f.dominated(values) {
+		return
+	}
+	stats.Evaluated++
+	if r := q.Evaluator.Evaluate(set); q.accept(r, stats) {
+		f.add(point{values, r})
+	}
+}
+
+// add puts a point on the front, removing any points it dominates.
+func (f *front) add(pt point) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, p := range f.points {
+		if dominates(p.values, pt.values) || (equalValues(p.values, pt.values) && f.compare(pt.result, p.result) <= 0) {
+			return
+		}
+	}
+	kept := f.points[:0]
+	for _, p := range f.points {
+		if !dominates(pt.values, p.values) && !equalValues(pt.values, p.values) {
+			kept = append(kept, p)
+		}
+	}
+	f.points = append(kept, pt)
+}
+
+// ParetoFront searches combinations of souls in the database for the sets that no other set beats
+// on every axis, such as the best damage at each speed. Like BestSet, it only considers sets that
+// match the query's soul types and that the query accepts; the query's optimizer is not used. When
+// several sets have the same value on every axis, the query's TieBreak picks one. The sets are
+// ordered from highest to lowest value on the first axis.
+func (db *SoulDb) ParetoFront(axes []Optimizer, q Query) []Result {
+	results, _, _ := db.ParetoFrontContext(context.Background(), axes, q)
+	return results
+}
+
+// ParetoFrontContext works like ParetoFront, but stops searching when ctx is done. It then returns
+// the front of the sets found so far along with the context's error. If an axis or the query isn't
+// valid, it returns the error without searching.
+func (db *SoulDb) ParetoFrontContext(ctx context.Context, axes []Optimizer, q Query) ([]Result, Stats, error) {
+	if len(axes) == 0 {
+		return nil, Stats{Covered: 1}, nil
+	}
+
+	if err := q.validateFilters(); err != nil {
+		return nil, Stats{}, err
+	}
+	objs := make([]*objective, len(axes))
+	for i, axis := range axes {
+		obj, err := axis.parsed()
+		if err != nil {
+			return nil, Stats{}, err
+		}
+		objs[i] = obj
+	}
+	slots := [6][]Soul{db.Slot1, db.Slot2, db.Slot3, db.Slot4, db.Slot5, db.Slot6}
+	pruned := q.prune(&slots, objs, 1)
+	for _, slot := range slots {
+		axes[0].sortByValue(q.Evaluator, slot)
+	}
+
+	f := front{axes: objs, compare: q.TieBreak.Then(defaultTieBreak)}
+	stats, err := q.search(ctx, slots, objs, &f)
+	stats.Pruned = pruned
+
+	sort.Slice(f.points, func(i, j int) bool {
+		a, b := f.points[i].values, f.points[j].values
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] > b[k]
+			}
+		}
+		return false
+	})
+	results := make([]Result, len(f.points))
+	for i, p := range f.points {
+		results[i] = p.result
+	}
+	return results, stats, err
+}
@@ -0,0 +1,62 @@
+package onmyoji
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// exhaustiveFront returns the value on each axis of every distinct point on the Pareto front.
+func exhaustiveFront(axes []Optimizer, results []Result) [][]float64 {
+	var points [][]float64
+	for _, r := range results {
+		values := make([]float64, len(axes))
+		for i, axis := range axes {
+			values[i] = axis.Value(r)
+		}
+		points = append(points, values)
+	}
+
+	var front [][]float64
+	for i, p := range points {
+		keep := true
+		for j, q := range points {
+			if dominates(q, p) || (j < i && equalValues(q, p)) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			front = append(front, p)
+		}
+	}
+	return front
+}
+
+func TestParetoFrontMatchesExhaustive(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	ev := Evaluator{Shikigami: shiki, Options: DamageOptions{Orbs: 5}}
+	crit := func(r Result) bool { return r.Crit >= 40 }
+
+	for seed := int64(2); seed <= 4; seed++ {
+		db := randomDb(seed, 6)
+		for _, axes := range [][]Optimizer{{"dmg", "spd"}, {"hp", "heal"}, {"spd", "dmg - 10*hp", "crit"}} {
+			expected := exhaustiveFront(axes, exhaustiveResults(db, Damage, ev, withShadow, crit))
+			actual := db.ParetoFront(axes, Query{Primaries: []string{"Shadow"}, Evaluator: ev, Accept: crit})
+
+			var values [][]float64
+			for _, r := range actual {
+				v := make([]float64, len(axes))
+				for i, axis := range axes {
+					v[i] = axis.Value(r)
+				}
+				values = append(values, v)
+			}
+			assert.ElementsMatch(t, expected, values, "seed %v, %v", seed, axes)
+			for i := 1; i < len(values); i++ {
+				assert.True(t, values[i-1][0] >= values[i][0], "seed %v, %v: not sorted", seed, axes)
+			}
+		}
+	}
+}
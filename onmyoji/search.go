@@ -0,0 +1,1059 @@
+package onmyoji
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sortByValue orders souls by how much they improve the optimized value on their own, so that good
+// sets are found early and more of the search can be skipped.
+func (o Optimizer) sortByValue(ev Evaluator, souls []Soul) {
+	obj := o.objective()
+	values := make([]float64, len(souls))
+	for i, sl := range souls {
+		values[i] = obj.evaluate(ev, NewSoulSet([6]Soul{sl}))
+	}
+	sort.Stable(byValue{souls, values})
+}
+
+type byValue struct {
+	souls  []Soul
+	values []float64
+}
+
+func (b byValue) Len() int           { return len(b.souls) }
+func (b byValue) Less(i, j int) bool { return b.values[i] > b.values[j] }
+func (b byValue) Swap(i, j int) {
+	b.souls[i], b.souls[j] = b.souls[j], b.souls[i]
+	b.values[i], b.values[j] = b.values[j], b.values[i]
+}
+
+// bounds describes what the remaining slots of a partial soul set could contribute.
+type bounds struct {
+	// profiles[k] holds, for each group of souls in slot k that share a main stat, a soul with the
+	// highest value of every stat in that group. Stats that can't change the fields are left out, so
+	// that groups which only differ in those stats are merged.
+	profiles [6][]Soul
+	// worst[k] holds the lowest value of every stat in slot k.
+	worst [6]Soul
+	// avail[k] counts how many of slots k-6 hold a soul of each type, indexed by kind.
+	avail [6][]int
+	// fields lists the attributes the search needs bounds on.
+	fields fieldSet
+	// upperOnly is true if the search only needs upper bounds, because what it maximizes never
+	// decreases when an attribute increases and no attribute has a maximum.
+	upperOnly bool
+	// limits holds the range each constrained attribute must end up in.
+	limits []limit
+	// spdLimit is the range the total speed of the souls must end up in, and maxSpd[k] the highest
+	// speed that slots k-6 could add to a set.
+	spdLimit [2]int
+	maxSpd   [7]int
+	// special lists the primaries and the soul types whose set effects could change those
+	// attributes and that a set can hold enough souls of, which the bounds try 2 and 4 souls of.
+	// Other types never have more than 2 souls that matter in a set.
+	special []kind
+	// groups lists the other soul types whose attribute bonuses could change those attributes,
+	// grouped by bonus. Types in a group are interchangeable, so the bounds only try how many pairs
+	// of each group a set could have.
+	groups [][]kind
+	// primaries lists the primary soul types, one of which must complete a set of 4.
+	primaries []kind
+}
+
+// limit is a Constraint on a field.
+type limit struct {
+	field    field
+	min, max float64
+}
+
+func newBounds(slots [6][]Soul, kinds *[6][]kind, objs []*objective, q *Query, m matcher) *bounds {
+	b := bounds{upperOnly: true, spdLimit: [2]int{math.MinInt32, math.MaxInt32}, primaries: m.primaries}
+	for _, obj := range objs {
+		for f, used := range obj.uses {
+			b.fields[f] = b.fields[f] || used
+		}
+		b.upperOnly = b.upperOnly && obj.increasing
+	}
+	for _, c := range q.Constraints {
+		l := limit{field: c.field(), min: float64(c.Min), max: math.Inf(1)}
+		if c.Max != 0 {
+			l.max = float64(c.Max)
+			b.upperOnly = false
+		}
+		b.fields[l.field] = true
+		b.limits = append(b.limits, l)
+		if l.field == spdField {
+			spd := q.Evaluator.Shikigami.Spd
+			if c.Min-spd > b.spdLimit[0] {
+				b.spdLimit[0] = c.Min - spd
+			}
+			if c.Max != 0 && c.Max-spd < b.spdLimit[1] {
+				b.spdLimit[1] = c.Max - spd
+			}
+		}
+	}
+
+	numKinds := 0
+	for _, slot := range kinds {
+		for _, typ := range slot {
+			if int(typ) >= numKinds {
+				numKinds = int(typ) + 1
+			}
+		}
+	}
+
+	seen := make([]bool, numKinds)
+	var byBonus [len(bonusNames)][]kind
+	for k := 5; k >= 0; k-- {
+		b.maxSpd[k] = b.maxSpd[k+1]
+		b.avail[k] = make([]int, numKinds)
+		if k < 5 {
+			copy(b.avail[k], b.avail[k+1])
+		}
+
+		inSlot := make([]bool, numKinds)
+		profiles := make(map[int]Soul)
+		for i, sl := range slots[k] {
+			if i == 0 {
+				b.worst[k] = sl
+			}
+			b.worst[k] = minStats(b.worst[k], sl)
+			main := mainStat(sl)
+			profiles[main] = maxStats(profiles[main], b.fields.relevant(sl))
+
+			typ := kinds[k][i]
+			if !inSlot[typ] {
+				inSlot[typ] = true
+				b.avail[k][typ]++
+			}
+
+			if seen[typ] {
+				continue
+			}
+			seen[typ] = true
+			if !m.allows(typ) {
+				continue
+			}
+			pieces := b.fields.pieces(typ)
+			if containsKind(m.primaries, typ) || (pieces > 0 && pieces <= m.most(typ)) {
+				b.special = append(b.special, typ)
+			} else if b.fields.affects(typ) {
+				bonus := typ.info().bonus
+				byBonus[bonus] = append(byBonus[bonus], typ)
+			}
+		}
+		for main := -1; main < 8; main++ {
+			profile, ok := profiles[main]
+			if !ok {
+				continue
+			}
+			// Skip groups that are no better than another group in every stat.
+			dominated := false
+			for other, alt := range profiles {
+				dominated = dominated || (other != main && maxStats(profile, alt) == alt && (profile != alt || other < main))
+			}
+			if !dominated {
+				b.profiles[k] = append(b.profiles[k], profile)
+			}
+			if profile.Spd > b.maxSpd[k]-b.maxSpd[k+1] {
+				b.maxSpd[k] = b.maxSpd[k+1] + profile.Spd
+			}
+		}
+	}
+	for _, group := range byBonus {
+		if len(group) > 0 {
+			b.groups = append(b.groups, group)
+		}
+	}
+	return &b
+}
+
+// relevant zeroes the stats of a soul that can't change any field in the set.
+func (fs *fieldSet) relevant(sl Soul) Soul {
+	stats := sl.stats()
+	for i, stat := range soulStats {
+		used := false
+		for _, f := range stat.fields {
+			used = used || fs[f]
+		}
+		if !used {
+			*stats[i] = 0
+		}
+	}
+	return sl
+}
+
+// mainStat guesses which percentage stat is the main stat of a soul, as the main stat is always
+// larger than any substat. It returns -1 if the soul has none.
+func mainStat(sl Soul) int {
+	main, max := -1, 0
+	for i, v := range [...]int{sl.AtkBonus, sl.Crit, sl.CritDmg, sl.Spd, sl.HPBonus, sl.DefBonus, sl.EffectHit, sl.EffectRes} {
+		if v > max {
+			main, max = i, v
+		}
+	}
+	return main
+}
+
+func maxStats(a, b Soul) Soul {
+	return combineStats(a, b, func(x, y int) bool { return x > y })
+}
+
+func minStats(a, b Soul) Soul {
+	return combineStats(a, b, func(x, y int) bool { return x < y })
+}
+
+// combineStats picks each stat from a if prefer returns true for it, and from b otherwise.
+func combineStats(a, b Soul, prefer func(x, y int) bool) Soul {
+	pick := func(x, y int) int {
+		if prefer(x, y) {
+			return x
+		}
+		return y
+	}
+	return Soul{
+		Atk:       pick(a.Atk, b.Atk),
+		AtkBonus:  pick(a.AtkBonus, b.AtkBonus),
+		Crit:      pick(a.Crit, b.Crit),
+		CritDmg:   pick(a.CritDmg, b.CritDmg),
+		Spd:       pick(a.Spd, b.Spd),
+		HP:        pick(a.HP, b.HP),
+		HPBonus:   pick(a.HPBonus, b.HPBonus),
+		Def:       pick(a.Def, b.Def),
+		DefBonus:  pick(a.DefBonus, b.DefBonus),
+		EffectHit: pick(a.EffectHit, b.EffectHit),
+		EffectRes: pick(a.EffectRes, b.EffectRes),
+	}
+}
+
+// completion tries the ways to complete a partial set, to find whether any of them could beat the
+// collector. Each searcher has its own, which it reuses for every partial set.
+type completion struct {
+	*bounds
+	ev        Evaluator
+	collector collector
+	k         int
+	// total and counts describe the partial set filled with the profiles and bonuses being tried.
+	total  Soul
+	counts kindCounts
+	lo, hi fieldValues
+}
+
+// canBeat returns whether the bounds on the attributes of some completion of the first k souls
+// satisfy the constraints and are admitted by the collector. It fills empty slots with the best
+// stats of each group of souls in that slot and tries every reachable combination of 2 and 4 soul
+// set bonuses, which gives an upper bound on every attribute of any completion because the
+// evaluators never decrease when a stat increases. Objectives that don't always increase with every
+// attribute also need a lower bound on each attribute, which comes from filling empty slots with the
+// worst stats in that slot and no further set bonuses.
+func (cp *completion) canBeat(souls [6]Soul, kinds [6]kind, k int) bool {
+	cp.k, cp.total, cp.counts = k, Soul{}, kindCounts{}
+	for i, sl := range souls[:k] {
+		cp.total = addStats(cp.total, sl)
+		cp.counts.add(kinds[i])
+	}
+	// Speed only comes from souls, so a set too slow for the constraints can be skipped cheaply.
+	if cp.total.Spd+cp.maxSpd[k] < cp.spdLimit[0] {
+		return false
+	}
+
+	if !cp.upperOnly {
+		worst := cp.total
+		for _, sl := range cp.worst[k:] {
+			worst = addStats(worst, sl)
+		}
+		cp.lo = cp.fields.compute(cp.ev, SoulSet{counts: cp.counts, total: worst})
+		for _, l := range cp.limits {
+			if cp.lo[l.field] > l.max {
+				return false
+			}
+		}
+	}
+	return cp.fill(k, cp.total)
+}
+
+// fill tries each profile for slot i, adding its stats to total.
+func (cp *completion) fill(i int, total Soul) bool {
+	if i == 6 {
+		cp.total = total
+		return cp.special(0, 6-cp.k)
+	}
+	for _, profile := range cp.profiles[i] {
+		if total.Spd+profile.Spd+cp.maxSpd[i+1] < cp.spdLimit[0] {
+			continue
+		}
+		if cp.fill(i+1, addStats(total, profile)) {
+			return true
+		}
+	}
+	return false
+}
+
+// special tries 4, 2 and no further souls of special type i and those after it, with left empty
+// slots to fill.
+func (cp *completion) special(i, left int) bool {
+	if i == len(cp.bounds.special) {
+		return cp.grouped(0, left)
+	}
+
+	typ := cp.bounds.special[i]
+	have := cp.counts.count(typ)
+	for _, target := range [...]int{4, 2} {
+		need := target - have
+		if need <= 0 || need > left || need > cp.avail[cp.k][typ] {
+			continue
+		}
+		cp.counts.set(typ, target)
+		beat := cp.special(i+1, left-need)
+		cp.counts.set(typ, have)
+		if beat {
+			return true
+		}
+	}
+	return cp.special(i+1, left)
+}
+
+// grouped tries every number of pairs of group i and those after it, with left empty slots to fill.
+// The types that need the fewest souls to complete a pair are used first.
+func (cp *completion) grouped(i, left int) bool {
+	if i == len(cp.groups) {
+		return cp.admits()
+	}
+
+	var pairs [6]kind
+	var needs [6]int
+	n, used := 0, 0
+	for need := 1; need <= 2; need++ {
+		for _, typ := range cp.groups[i] {
+			if n < len(pairs) && used+need <= left && need <= cp.avail[cp.k][typ] && cp.counts.count(typ) == 2-need {
+				pairs[n], needs[n] = typ, need
+				cp.counts.set(typ, 2)
+				n++
+				used += need
+			}
+		}
+	}
+	beat := cp.grouped(i+1, left-used)
+	for n > 0 {
+		n--
+		used -= needs[n]
+		cp.counts.set(pairs[n], 2-needs[n])
+		// Fewer pairs only lower the bounds, so they are only worth trying if the slots they free up
+		// could go to later groups.
+		if !beat && i < len(cp.groups)-1 {
+			beat = cp.grouped(i+1, left-used)
+		}
+	}
+	return beat
+}
+
+// admits returns whether the profiles and bonuses being tried complete a primary and give
+// attributes that satisfy the constraints and that the collector admits.
+func (cp *completion) admits() bool {
+	if len(cp.primaries) > 0 {
+		complete := false
+		for _, p := range cp.primaries {
+			complete = complete || cp.counts.count(p) >= 4
+		}
+		if !complete {
+			return false
+		}
+	}
+	cp.hi = cp.fields.compute(cp.ev, SoulSet{counts: cp.counts, total: cp.total})
+	for _, l := range cp.limits {
+		if cp.hi[l.field] < l.min {
+			return false
+		}
+	}
+	return cp.collector.admits(&cp.lo, &cp.hi)
+}
+
+// matcher tracks which soul types have been used and rejects combinations that can't satisfy the
+// requested layout, or primaries and secondaries.
+type matcher struct {
+	// primaries lists the types one of which must complete a set of 4. With a layout, they are the
+	// types of its group of 4 or more souls, if only some types can fill it.
+	primaries, secondaries []kind
+	// layout is the requested layout, which replaces the primaries and secondaries if it isn't nil.
+	layout *layout
+}
+
+func newMatcher(q *Query) matcher {
+	var m matcher
+	if m.layout = q.Layout.layout(); m.layout != nil {
+		m.primaries = m.layout.fours()
+		return m
+	}
+	for _, p := range q.Primaries {
+		m.primaries = append(m.primaries, internKind(p))
+	}
+	for _, sec := range q.Secondaries {
+		m.secondaries = append(m.secondaries, internKind(sec))
+	}
+	return m
+}
+
+// matched describes the soul types of a partial set.
+type matched struct {
+	// primary is the primary type used, if primCount is above zero.
+	primary   kind
+	primCount int
+	// secs counts the souls of each secondary type, which is at most 2. With a layout, it counts the
+	// souls of every type instead.
+	secs kindCounts
+}
+
+// match adds a soul of type typ to slot k of a partial set, returning false if the set can no
+// longer match.
+func (m matcher) match(k int, typ kind, st matched) (matched, bool) {
+	if m.layout != nil {
+		st.secs.add(typ)
+		return st, m.layout.shortfall(&st.secs) <= 5-k
+	}
+	st, ok := m.add(typ, st)
+	if !ok {
+		return st, false
+	}
+	// Starting once we have 3 souls, test that we have sufficient copies of the primary soul
+	// type to complete a set of 4. If not, skip this set of combinations.
+	if len(m.primaries) > 0 && st.primCount < k-1 {
+		return st, false
+	}
+	// If we haven't found enough secondaries by the 5th soul, skip.
+	if k == 4 {
+		if st.primCount > 0 {
+			return st, st.secs.len >= 1
+		}
+		return st, st.secs.len >= 3
+	}
+	return st, true
+}
+
+func (m matcher) add(typ kind, st matched) (matched, bool) {
+	if containsKind(m.primaries, typ) {
+		if st.primCount > 0 && st.primary != typ {
+			return st, false
+		}
+		st.primary = typ
+		st.primCount++
+		return st, true
+	}
+	if containsKind(m.secondaries, typ) || len(m.secondaries) == 0 {
+		// If matched to secondaries, or no primaries or secondaries were requested, add to secondaries.
+		if st.secs.count(typ) == 2 {
+			// Don't allow more than two of a secondary.
+			return st, false
+		}
+		st.secs.add(typ)
+
+		if (len(m.primaries) > 0 && st.secs.len > 2) || st.secs.len > 4 {
+			// If primary is requested, allow up to 2 secondary. Else allow up to 4 secondaries.
+			return st, false
+		}
+		return st, true
+	}
+	// If primaries or secondaries were requested, then we want to stop if we didn't match either.
+	return st, false
+}
+
+// allows returns whether a matching set can hold a soul of the type.
+func (m matcher) allows(typ kind) bool {
+	if m.layout != nil {
+		return m.layout.allows(typ)
+	}
+	return len(m.secondaries) == 0 || containsKind(m.primaries, typ) || containsKind(m.secondaries, typ)
+}
+
+// most returns how many souls of a type a matching set can hold.
+func (m matcher) most(typ kind) int {
+	switch {
+	case m.layout != nil:
+		return m.layout.most(typ)
+	case containsKind(m.primaries, typ):
+		return 6
+	}
+	return 2
+}
+
+// Query describes the soul set to search for.
+type Query struct {
+	// Primaries lists soul types that can make up 4 souls of the set. If empty, the set is made of
+	// up to 4 pairs of souls.
+	Primaries []string
+	// Secondaries lists soul types allowed in the rest of the set. If empty, any type is allowed.
+	Secondaries []string
+	// Layout, if not empty, sets how the souls are grouped by type instead of Primaries and
+	// Secondaries, which are then ignored.
+	Layout Layout
+	// MainStats requires the soul in each slot to have a main stat, such as "spd" for slot 2 in
+	// MainStats[1]. An empty entry allows any main stat. Souls that don't record their main stat
+	// never meet a requirement.
+	MainStats [6]string
+	Optimize  Optimizer
+	Evaluator Evaluator
+	// Constraints limit the attributes of acceptable results. The search skips partial sets that
+	// can no longer satisfy them.
+	Constraints []Constraint
+	// Accept returns whether a result satisfies any other constraints. If nil, every result that
+	// satisfies Constraints is acceptable. The search assumes Accept never rejects a set for being
+	// better at what it optimizes, and otherwise only skips a soul for one that matches its stats.
+	Accept func(Result) bool
+	// TieBreak orders results with the same optimized value. Any ties it leaves are broken by
+	// preferring higher HP, damage, heal, speed and crit, and then by comparing souls.
+	TieBreak Comparator
+	// Workers is the number of goroutines that search at once. If zero, it is GOMAXPROCS.
+	Workers int
+	// Strategy picks how BestSets searches. If empty, it checks every combination.
+	Strategy Strategy
+	// Seed seeds the random choices of the Anneal and Genetic strategies, which find the same sets
+	// every time for the same seed.
+	Seed int64
+	// Evaluations is roughly how many sets the strategies other than Exhaustive evaluate. If zero,
+	// it is 400000.
+	Evaluations int
+}
+
+// Validate returns an error if the query's optimizer, layout, constraints, main stats or strategy
+// aren't valid. The searches return this error rather than searching.
+func (q *Query) Validate() error {
+	if err := q.Optimize.Validate(); err != nil {
+		return err
+	}
+	return q.validateFilters()
+}
+
+// validateFilters checks everything Validate does except the optimizer, which ParetoFront doesn't
+// use.
+func (q *Query) validateFilters() error {
+	if q.Layout != "" {
+		if err := q.Layout.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, c := range q.Constraints {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+	for k, stat := range q.MainStats {
+		if stat == "" {
+			continue
+		}
+		if err := CheckMainStat(k+1, stat); err != nil {
+			return err
+		}
+	}
+	return q.Strategy.Validate()
+}
+
+func (q *Query) workers() int {
+	if q.Workers > 0 {
+		return q.Workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// accept returns whether a result satisfies the constraints, counting what rejects it in stats.
+func (q *Query) accept(r Result, stats *Stats) bool {
+	ok := true
+	for i, c := range q.Constraints {
+		if !c.Allows(r) {
+			stats.Rejected[i]++
+			ok = false
+		}
+	}
+	if ok && q.Accept != nil && !q.Accept(r) {
+		stats.RejectedByAccept++
+		ok = false
+	}
+	return ok
+}
+
+// Allows returns whether a result is one the query could find: its souls are of the types and
+// have the main stats the query asks for, and it satisfies the constraints and Accept. A query that
+// isn't valid allows nothing.
+func (q *Query) Allows(r Result) bool {
+	if q.Validate() != nil {
+		return false
+	}
+	m := newMatcher(q)
+	var st matched
+	for k, sl := range r.Souls.souls {
+		var ok bool
+		if st, ok = m.match(k, internKind(sl.Type), st); !ok || !q.hasMain(k, sl) {
+			return false
+		}
+	}
+	for _, c := range q.Constraints {
+		if !c.Allows(r) {
+			return false
+		}
+	}
+	return q.Accept == nil || q.Accept(r)
+}
+
+// Constraint limits an attribute of a result to a range.
+type Constraint struct {
+	// Attribute names the attribute, using the names accepted by Attribute.
+	Attribute string
+	// Min and Max bound the attribute. A Max of zero leaves the range open above.
+	Min, Max int
+}
+
+// Validate returns an error if the constraint's attribute is unknown.
+func (c Constraint) Validate() error {
+	_, err := Attribute(c.Attribute)
+	return err
+}
+
+// Allows returns whether a result satisfies the constraint. A constraint on an unknown attribute
+// allows nothing.
+func (c Constraint) Allows(r Result) bool {
+	f, ok := fieldNames[strings.ToLower(c.Attribute)]
+	if !ok {
+		return false
+	}
+	v := f.of(r)
+	return v >= c.Min && (c.Max == 0 || v <= c.Max)
+}
+
+// String describes the constraint as its attribute and range, such as "spd 117-127" or "crit 100-".
+func (c Constraint) String() string {
+	if c.Max == 0 {
+		return fmt.Sprintf("%v %v-", c.Attribute, c.Min)
+	}
+	return fmt.Sprintf("%v %v-%v", c.Attribute, c.Min, c.Max)
+}
+
+// field returns the constrained field. It panics if the constraint isn't valid, which the searches
+// check first.
+func (c Constraint) field() field {
+	f, ok := fieldNames[strings.ToLower(c.Attribute)]
+	if !ok {
+		panic(c.Validate())
+	}
+	return f
+}
+
+// Comparator returns the comparator used to rank results of the query at every stage of a search.
+func (q *Query) Comparator() Comparator {
+	return q.Optimize.Comparator().Then(q.TieBreak).Then(defaultTieBreak)
+}
+
+// ranking keeps the n best results found by any searcher, best first.
+type ranking struct {
+	obj     *objective
+	compare Comparator
+	n       int
+
+	mu      sync.Mutex
+	results []Result
+	// threshold is the optimized value of the worst ranked result once n results are ranked. It
+	// only grows, so searchers may read it without holding the lock. It holds the bits of a float64.
+	threshold uint64
+}
+
+func newRanking(obj *objective, compare Comparator, n int) *ranking {
+	return &ranking{obj: obj, compare: compare, n: n, threshold: math.Float64bits(math.Inf(-1))}
+}
+
+func (r *ranking) minimum() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&r.threshold))
+}
+
+// add ranks a result, dropping the worst result if there are now more than n.
+func (r *ranking) add(res Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := sort.Search(len(r.results), func(i int) bool { return r.compare(res, r.results[i]) >= 0 })
+	if i == r.n || (i < len(r.results) && r.compare(res, r.results[i]) == 0) {
+		// Not good enough, or already ranked.
+		return
+	}
+
+	r.results = append(r.results, Result{})
+	copy(r.results[i+1:], r.results[i:])
+	r.results[i] = res
+	if len(r.results) > r.n {
+		r.results = r.results[:r.n]
+	}
+	if len(r.results) == r.n {
+		atomic.StoreUint64(&r.threshold, math.Float64bits(r.obj.value(r.results[r.n-1])))
+	}
+}
+
+// collector gathers the complete sets found by a search, and decides which partial sets are worth
+// completing.
+type collector interface {
+	// admits returns whether a set whose attributes lie between lo and hi might be collected.
+	admits(lo, hi *fieldValues) bool
+	// collect considers a complete set, counting any constraints that reject it in stats.
+	collect(q *Query, set SoulSet, stats *Stats)
+}
+
+func (r *ranking) admits(lo, hi *fieldValues) bool {
+	return r.obj.upper(lo, hi) >= r.minimum()
+}
+
+func (r *ranking) collect(q *Query, set SoulSet, stats *Stats) {
+	// Results with the same value as the worst ranked result might still win on a tie break.
+	if r.obj.evaluate(q.Evaluator, set) < r.minimum() {
+		return
+	}
+	stats.Evaluated++
+	if res := q.Evaluator.Evaluate(set); q.accept(res, stats) {
+		r.add(res)
+	}
+}
+
+// searcher explores every set that starts with a particular pair of slot 1 and 2 souls, skipping
+// partial sets whose bounds the collector doesn't admit.
+type searcher struct {
+	matcher
+	*Query
+	slots *[6][]Soul
+	// slotKinds holds the kind of each soul in slots.
+	slotKinds *[6][]kind
+	bounds    completion
+	collector collector
+	// stop is set to 1 when the search should end early.
+	stop *int32
+
+	souls [6]Soul
+	kinds [6]kind
+	// stats counts what the searcher has done. Its Covered is the fraction of all combinations of
+	// the slots that the searcher has either checked or skipped.
+	stats Stats
+}
+
+// search explores the sets that complete the first k souls, which make up the given fraction of all
+// combinations of the slots.
+func (s *searcher) search(k int, st matched, fraction float64) {
+	if atomic.LoadInt32(s.stop) != 0 {
+		return
+	}
+
+	if k == 6 {
+		s.stats.Completed++
+		s.collector.collect(s.Query, newSoulSet(s.souls, s.kinds), &s.stats)
+		s.stats.Covered += fraction
+		return
+	}
+
+	if len(s.slots[k]) == 0 {
+		s.stats.Covered += fraction
+		return
+	}
+	if !s.bounds.canBeat(s.souls, s.kinds, k) {
+		s.stats.Skipped++
+		s.stats.Covered += fraction
+		return
+	}
+
+	fraction /= float64(len(s.slots[k]))
+	for i, sl := range s.slots[k] {
+		typ := s.slotKinds[k][i]
+		st, ok := s.match(k, typ, st)
+		if !ok {
+			s.stats.Covered += fraction
+			continue
+		}
+		s.souls[k], s.kinds[k] = sl, typ
+		s.search(k+1, st, fraction)
+	}
+}
+
+// unit is a pair of souls for the first two slots, whose completions a worker searches.
+type unit struct {
+	souls    [2]Soul
+	kinds    [2]kind
+	st       matched
+	fraction float64
+}
+
+// split sends a unit for each pair of souls in the first two slots that matches the query and
+// could still beat the collector.
+func (s *searcher) split(units chan<- unit) {
+	fraction := 1 / float64(len(s.slots[0]))
+	for i, sl1 := range s.slots[0] {
+		if atomic.LoadInt32(s.stop) != 0 {
+			return
+		}
+		typ1 := s.slotKinds[0][i]
+		st, ok := s.match(0, typ1, matched{})
+		if !ok {
+			s.stats.Covered += fraction
+			continue
+		}
+		s.souls[0], s.kinds[0] = sl1, typ1
+		if len(s.slots[1]) == 0 {
+			s.stats.Covered += fraction
+			continue
+		}
+		if !s.bounds.canBeat(s.souls, s.kinds, 1) {
+			s.stats.Skipped++
+			s.stats.Covered += fraction
+			continue
+		}
+
+		fraction := fraction / float64(len(s.slots[1]))
+		for j, sl2 := range s.slots[1] {
+			typ2 := s.slotKinds[1][j]
+			st, ok := s.match(1, typ2, st)
+			if !ok {
+				s.stats.Covered += fraction
+				continue
+			}
+			units <- unit{[2]Soul{sl1, sl2}, [2]kind{typ1, typ2}, st, fraction}
+		}
+	}
+}
+
+// Stats describes how a search went.
+type Stats struct {
+	// Covered is the fraction of soul combinations that the search either checked or showed it
+	// could skip. It is 1 unless the search was stopped early.
+	Covered float64
+	// Pruned counts, for each slot, the souls left out of the search because enough other souls of
+	// the same type were at least as good.
+	Pruned [6]int
+	// Completed counts the complete sets the search reached, and Evaluated those among them that
+	// might have been good enough to keep, so every attribute was calculated and checked.
+	Completed, Evaluated int64
+	// Rejected counts, for each of the query's Constraints, the evaluated sets outside its range.
+	// A set can be rejected by several constraints. RejectedByAccept counts the evaluated sets that
+	// satisfied the constraints but that Accept rejected.
+	Rejected         []int64
+	RejectedByAccept int64
+	// Skipped counts the partial sets that the search didn't complete, because none of their
+	// completions could satisfy the constraints and beat the sets already found.
+	Skipped int64
+	// Elapsed is how long the search took.
+	Elapsed time.Duration
+	// Bound is the highest optimized value that any acceptable set could have, or -Inf if no set
+	// can be acceptable. Once every combination has been checked, it is the value of the best set;
+	// otherwise it comes from the bounds used to skip partial sets, so it can be well above the
+	// best set that exists.
+	Bound float64
+	// Reused is true if the search started from the results of an earlier search, and only checked
+	// sets with at least one of NewSouls souls that the earlier search didn't have.
+	Reused   bool
+	NewSouls int
+}
+
+// add sums the counts in o into s. It leaves Bound, Reused and NewSouls alone.
+func (s *Stats) add(o Stats) {
+	s.Covered += o.Covered
+	for k := range s.Pruned {
+		s.Pruned[k] += o.Pruned[k]
+	}
+	s.Completed += o.Completed
+	s.Evaluated += o.Evaluated
+	if s.Rejected == nil {
+		s.Rejected = make([]int64, len(o.Rejected))
+	}
+	for i := range o.Rejected {
+		s.Rejected[i] += o.Rejected[i]
+	}
+	s.RejectedByAccept += o.RejectedByAccept
+	s.Skipped += o.Skipped
+	s.Elapsed += o.Elapsed
+}
+
+// then adds the counts of a later search for the same query into s, keeping the later search's
+// coverage and pruning.
+func (s *Stats) then(o Stats) {
+	covered, pruned, bound := o.Covered, o.Pruned, o.Bound
+	s.add(o)
+	s.Covered, s.Pruned, s.Bound = covered, pruned, bound
+}
+
+// hasMain returns whether a soul in slot k has the main stat the query asks for there.
+func (q *Query) hasMain(k int, sl Soul) bool {
+	return q.MainStats[k] == "" || sl.hasMain(q.MainStats[k])
+}
+
+// prune removes the souls from each slot that don't have the main stats the query asks for, and
+// those that aren't needed to find the n best sets for the objectives and constraints. It returns
+// how many of the souls with the right main stats it removed from each slot.
+func (q *Query) prune(slots *[6][]Soul, objs []*objective, n int) [6]int {
+	d := newDominance(objs, q)
+	var pruned [6]int
+	for k, slot := range slots {
+		if q.MainStats[k] != "" {
+			var kept []Soul
+			for _, sl := range slot {
+				if q.hasMain(k, sl) {
+					kept = append(kept, sl)
+				}
+			}
+			slot = kept
+		}
+		slots[k] = d.keepTop(slot, n)
+		pruned[k] = len(slot) - len(slots[k])
+	}
+	return pruned
+}
+
+// search gives every set of the slots that matches the query's primaries and secondaries to the
+// collector, skipping partial sets that the collector doesn't admit. The objectives are those the
+// collector bounds. If ctx is done before the search finishes, it stops early and returns the
+// context's error.
+func (q *Query) search(ctx context.Context, slots [6][]Soul, objs []*objective, c collector) (Stats, error) {
+	start := time.Now()
+	// Intern the soul types up front, so the search can count them without maps.
+	kinds := internSlots(&slots)
+	m := newMatcher(q)
+	b := completion{bounds: newBounds(slots, &kinds, objs, q, m), ev: q.Evaluator, collector: c}
+
+	stop, release := stopWhenDone(ctx)
+	defer release()
+
+	units := make(chan unit)
+	workers := q.workers()
+	searchers := make([]searcher, workers)
+	var wg sync.WaitGroup
+	for i := range searchers {
+		s := &searchers[i]
+		*s = q.searcher(m, &slots, &kinds, b, c, stop)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range units {
+				s.souls[0], s.souls[1] = u.souls[0], u.souls[1]
+				s.kinds[0], s.kinds[1] = u.kinds[0], u.kinds[1]
+				s.search(2, u.st, u.fraction)
+			}
+		}()
+	}
+
+	// The first two slots are split into units here, so that a slot 1 soul with a large subtree is
+	// shared between workers. Subtrees that can't beat the collector are skipped before splitting.
+	split := q.searcher(m, &slots, &kinds, b, c, stop)
+	split.split(units)
+	close(units)
+	wg.Wait()
+
+	stats := split.stats
+	for _, s := range searchers {
+		stats.add(s.stats)
+	}
+	stats.Elapsed = time.Since(start)
+	if atomic.LoadInt32(stop) != 0 {
+		return stats, ctx.Err()
+	}
+	stats.Covered = 1
+	return stats, nil
+}
+
+// stopWhenDone returns a flag that is set to 1 once ctx is done, so that searchers can check it
+// cheaply. Call release when the search ends.
+func stopWhenDone(ctx context.Context) (stop *int32, release func()) {
+	stop = new(int32)
+	if ctx.Err() != nil {
+		*stop = 1
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32(stop, 1)
+		case <-done:
+		}
+	}()
+	return stop, func() { close(done) }
+}
+
+// internSlots returns the kind of each soul in the slots.
+func internSlots(slots *[6][]Soul) [6][]kind {
+	var kinds [6][]kind
+	for k, slot := range slots {
+		kinds[k] = make([]kind, len(slot))
+		for i, sl := range slot {
+			kinds[k][i] = internKind(sl.Type)
+		}
+	}
+	return kinds
+}
+
+func (q *Query) searcher(m matcher, slots *[6][]Soul, kinds *[6][]kind, b completion, c collector, stop *int32) searcher {
+	s := searcher{matcher: m, Query: q, slots: slots, slotKinds: kinds, bounds: b, collector: c, stop: stop}
+	s.stats.Rejected = make([]int64, len(q.Constraints))
+	return s
+}
+
+// BestSet searches combinations of souls in the database for the set that maximizes the query's
+// optimizer. It only considers sets that include at least 4 of a primary soul (if primaries are
+// given) and that the query accepts. Partial sets that can't beat the best set found so far are
+// skipped, so the result is the same as checking every combination. If no set is acceptable, or
+// the query isn't valid, it returns an empty Result.
+func (db *SoulDb) BestSet(q Query) Result {
+	if best := db.BestSets(1, q); len(best) > 0 {
+		return best[0]
+	}
+	return Result{}
+}
+
+// BestSets works like BestSet, but returns up to n distinct sets ranked from best to worst by the
+// query's Comparator.
+func (db *SoulDb) BestSets(n int, q Query) []Result {
+	results, _, _ := db.BestSetsContext(context.Background(), n, q)
+	return results
+}
+
+// BestSetsContext works like BestSets, but stops searching when ctx is done. It then returns the
+// best sets found so far along with the context's error. If the query isn't valid, it returns the
+// error from Validate without searching.
+func (db *SoulDb) BestSetsContext(ctx context.Context, n int, q Query) ([]Result, Stats, error) {
+	return db.bestSets(ctx, n, q, nil)
+}
+
+// bestSets finds the n best sets for the query, reusing an earlier search if prev isn't nil and
+// its results still stand.
+func (db *SoulDb) bestSets(ctx context.Context, n int, q Query, prev *Previous) ([]Result, Stats, error) {
+	if err := q.Validate(); err != nil {
+		return nil, Stats{Bound: math.Inf(-1)}, err
+	}
+	if n < 1 {
+		return nil, Stats{Covered: 1, Bound: math.Inf(-1)}, nil
+	}
+
+	opt := q.Optimize
+	objs := []*objective{opt.objective()}
+	slots := [6][]Soul{db.Slot1, db.Slot2, db.Slot3, db.Slot4, db.Slot5, db.Slot6}
+	pruned := q.prune(&slots, objs, n)
+	if q.Strategy.heuristic() {
+		results, stats, err := q.heuristicSearch(ctx, slots, objs[0], n)
+		stats.Pruned = pruned
+		return results, stats, err
+	}
+
+	for _, slot := range slots {
+		opt.sortByValue(q.Evaluator, slot)
+	}
+	rank := newRanking(opt.objective(), q.Comparator(), n)
+	var stats Stats
+	var err error
+	if prev.reusable(db, n) {
+		stats, err = q.searchSince(ctx, slots, objs, rank, prev)
+	} else {
+		stats, err = q.search(ctx, slots, objs, rank)
+	}
+	stats.Pruned = pruned
+	stats.Bound = math.Inf(-1)
+	if err != nil {
+		stats.Bound = q.upperBound(slots, objs[0])
+	} else if len(rank.results) > 0 {
+		stats.Bound = rank.obj.value(rank.results[0])
+	}
+	return rank.results, stats, err
+}
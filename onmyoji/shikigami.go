@@ -5,9 +5,10 @@ import (
 	"strings"
 )
 
-// Shikigami encapsulates a shikigami's damage-related attributes.
+// Shikigami encapsulates a shikigami's attributes.
 type Shikigami struct {
 	HP, Atk, Spd, Crit, CritDmg int
+	Def, EffectHit, EffectRes   int
 	Multihit                    bool
 }
 
@@ -19,6 +20,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:     11,
 		CritDmg:  160,
 		Spd:      117,
+		Def:      353,
 		Multihit: true,
 	},
 	"ibaraki doji": {
@@ -27,6 +29,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:    10,
 		CritDmg: 150,
 		Spd:     112,
+		Def:     375,
 	},
 	"ubume": {
 		HP:       10823,
@@ -34,6 +37,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:     10,
 		CritDmg:  150,
 		Spd:      113,
+		Def:      353,
 		Multihit: true,
 	},
 	"kamikui g5": {
@@ -41,6 +45,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:    8,
 		CritDmg: 150,
 		Spd:     118,
+		Def:     309,
 	},
 	"kamikui": {
 		HP:      10709,
@@ -48,6 +53,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:    8,
 		CritDmg: 150,
 		Spd:     118,
+		Def:     397,
 	},
 	"shuten doji": {
 		HP:       11165,
@@ -55,6 +61,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:     10,
 		CritDmg:  150,
 		Spd:      113,
+		Def:      375,
 		Multihit: true,
 	},
 	"tamamonomae": {
@@ -63,12 +70,14 @@ var shikigamis = map[string]Shikigami{
 		Crit:    12,
 		CritDmg: 160,
 		Spd:     110,
+		Def:     353,
 	},
 	"nekomata": {
 		Atk:      3002,
 		Crit:     10,
 		CritDmg:  150,
 		Spd:      118,
+		Def:      331,
 		Multihit: true,
 	},
 	"kisei": {
@@ -77,6 +86,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:     8,
 		CritDmg:  150,
 		Spd:      106,
+		Def:      397,
 		Multihit: true,
 	},
 	"shiranui": {
@@ -85,6 +95,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:    10,
 		CritDmg: 150,
 		Spd:     117,
+		Def:     353,
 	},
 	"sp ibaraki doji": {
 		HP:      10254,
@@ -92,6 +103,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:    15,
 		CritDmg: 150,
 		Spd:     112,
+		Def:     375,
 	},
 	"ryomen": {
 		HP:       10482,
@@ -99,6 +111,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:     10,
 		CritDmg:  150,
 		Spd:      109,
+		Def:      375,
 		Multihit: true,
 	},
 	"bukkuman": {
@@ -107,6 +120,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:    8,
 		CritDmg: 150,
 		Spd:     109,
+		Def:     441,
 	},
 	"ootengu": {
 		HP:       10026,
@@ -114,6 +128,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:     10,
 		CritDmg:  150,
 		Spd:      110,
+		Def:      375,
 		Multihit: true,
 	},
 	"kuro": {
@@ -122,6 +137,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:     9,
 		CritDmg:  150,
 		Spd:      109,
+		Def:      353,
 		Multihit: true,
 	},
 	"orochi": {
@@ -130,6 +146,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:    10,
 		CritDmg: 150,
 		Spd:     118,
+		Def:     397,
 	},
 	"inuyasha": {
 		HP:       11393,
@@ -137,6 +154,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:     10,
 		CritDmg:  150,
 		Spd:      114,
+		Def:      375,
 		Multihit: true,
 	},
 	"sp crimson yoto": {
@@ -145,6 +163,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:    12,
 		CritDmg: 150,
 		Spd:     111,
+		Def:     353,
 	},
 	"sp blazing tamamanomae": {
 		HP:       12532,
@@ -152,6 +171,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:     12,
 		CritDmg:  160,
 		Spd:      115,
+		Def:      353,
 		Multihit: true,
 	},
 	"sp shuten doji": {
@@ -160,6 +180,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:    10,
 		CritDmg: 150,
 		Spd:     109,
+		Def:     397,
 	},
 	"ushi no toki g5": {
 		HP:      7963,
@@ -167,6 +188,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:    10,
 		CritDmg: 150,
 		Spd:     117,
+		Def:     309,
 	},
 	"ushi no toki": {
 		HP:       11165,
@@ -174,6 +196,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:     10,
 		CritDmg:  150,
 		Spd:      117,
+		Def:      375,
 		Multihit: true,
 	},
 	"suzuka gozen": {
@@ -182,6 +205,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:     10,
 		CritDmg:  150,
 		Spd:      110,
+		Def:      375,
 		Multihit: true,
 	},
 	"takiyashahime": {
@@ -190,6 +214,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:     10,
 		CritDmg:  150,
 		Spd:      120,
+		Def:      353,
 		Multihit: true,
 	},
 	"kanihime": {
@@ -198,6 +223,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:    8,
 		CritDmg: 150,
 		Spd:     108,
+		Def:     419,
 	},
 	"kinnara": {
 		HP:       10709,
@@ -205,6 +231,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:     15,
 		CritDmg:  160,
 		Spd:      115,
+		Def:      353,
 		Multihit: true,
 	},
 	"senhime": {
@@ -213,6 +240,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:    8,
 		CritDmg: 121,
 		Spd:     121,
+		Def:     441,
 	},
 	"sp otakemaru": {
 		HP:      11393,
@@ -220,6 +248,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:    10,
 		CritDmg: 150,
 		Spd:     115,
+		Def:     375,
 	},
 	"asura": {
 		HP:      11279,
@@ -227,6 +256,7 @@ var shikigamis = map[string]Shikigami{
 		Crit:    10,
 		CritDmg: 150,
 		Spd:     119,
+		Def:     353,
 	},
 }
 
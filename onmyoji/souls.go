@@ -4,11 +4,10 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-
-	"github.com/benbjohnson/immutable"
 )
 
-// Optimizer represents what to optimize for.
+// Optimizer represents what to optimize for. Besides the constants below, it can be an arithmetic
+// expression over dmg, heal, hp, crit, spd, atk, critdmg, def, effecthit and effectres, such as "0.7*dmg + 0.3*hp + 50*spd".
 type Optimizer string
 
 // Constants for selecting what to optimize.
@@ -18,121 +17,49 @@ const (
 	Heal             = "Heal"
 )
 
-// A comparison function that returns +1 if s1 is strictly better than s2,
-// -1 if s1 is not better in any way than s2, and 0 otherwise.
-// Only compares those with the same Spd because constraints may require odd combinations of spd.
-func (o Optimizer) comp(s1, s2 Soul) int {
-	if s1.Spd != s2.Spd {
-		return 0
-	}
-
-	switch o {
-	case Damage:
-		if s1.Crit > s2.Crit && s1.CritDmg > s2.CritDmg && s1.Atk > s2.Atk && s1.AtkBonus > s2.AtkBonus {
-			return 1
-		} else if s1.Crit <= s2.Crit && s1.CritDmg <= s2.CritDmg && s1.Atk <= s2.Atk && s1.AtkBonus <= s2.AtkBonus {
-			return -1
-		}
-		return 0
-	case HP:
-		if s1.HP > s2.HP && s1.HPBonus > s2.HPBonus {
-			return 1
-		} else if s1.HP <= s2.HP && s1.HPBonus <= s2.HPBonus {
-			return -1
-		}
-		return 0
-	case Heal:
-		if s1.HP > s2.HP && s1.HPBonus > s2.HPBonus && s1.Crit > s2.Crit && s1.CritDmg > s2.CritDmg {
-			return 1
-		} else if s1.HP <= s2.HP && s1.HPBonus <= s2.HPBonus && s1.Crit <= s2.Crit && s1.CritDmg <= s2.CritDmg {
-			return -1
-		}
-		return 0
-	}
-	panic("unknown optimizer")
-}
-
-// Remove all souls that are strictly worse than another soul of the same type.
-func (o Optimizer) bestOf(souls []Soul) []Soul {
-	soulsByType := make(map[string][]Soul)
-	for _, soul := range souls {
-		soulsOfType, ok := soulsByType[soul.Type]
-		if !ok {
-			soulsByType[soul.Type] = []Soul{soul}
-			continue
-		}
-
-		eliminated := false
-		for i, alt := range soulsOfType {
-			// if soul is strictly better, replace alt; if soul is not better in any way, skip soul; else add soul
-			if comp := o.comp(soul, alt); comp > 0 {
-				soulsOfType[i] = soul
-				eliminated = true
-				break
-			} else if comp < 0 {
-				eliminated = true
-				break
-			}
-		}
-		if !eliminated {
-			soulsByType[soul.Type] = append(soulsOfType, soul)
-		}
-	}
-
-	result := make([]Soul, 0)
-	for _, soulsOfType := range soulsByType {
-		result = append(result, soulsOfType...)
-	}
-	return result
-}
-
-// soulTypes map the name of souls to their 2-soul attribute bonus.
-var soulTypes = map[string]string{
-	"harpy":              "atk bonus",
-	"watcher":            "atk bonus",
-	"house imp":          "atk bonus",
-	"scarlet":            "atk bonus",
-	"soultaker":          "atk bonus",
-	"nightwing":          "atk bonus",
-	"kyoukotsu":          "atk bonus",
-	"tomb guard":         "crit",
-	"shadow":             "crit",
-	"fenikkusu":          "crit",
-	"claws":              "crit",
-	"samisen":            "crit",
-	"seductress":         "crit",
-	"tree spirit":        "hp bonus",
-	"soul edge":          "hp bonus",
-	"priestess":          "hp bonus",
-	"mirror lady":        "hp bonus",
-	"boroboroton":        "hp bonus",
-	"jizo statue":        "hp bonus",
-	"holy flame":         "hp bonus",
-	"nuribotoke":         "hp bonus",
-	"fortune cat":        "def bonus",
-	"azure basan":        "effect hit",
-	"namazu":             "",
-	"odokuro":            "",
-	"tsuchigumo":         "",
-	"ghostly songstress": "",
-}
-
 // SoulSetBonus returns the 2-soul attribute bonus for a set.
 func SoulSetBonus(name string) (string, error) {
-	if bonus, ok := soulTypes[strings.ToLower(name)]; ok {
-		return bonus, nil
+	if k, ok := lookupKind(name); ok && k.known() {
+		return bonusNames[k.info().bonus], nil
 	}
 	return "", fmt.Errorf("unknown soul type %v", name)
 }
 
 // Soul contains the name of the soul and stats relevant to damage output.
 type Soul struct {
+	// ID identifies the soul in its SoulDb, so that identical souls can be told apart. Zero means
+	// the soul hasn't been given an ID.
+	ID                                             int `yaml:",omitempty"`
 	Type                                           string
-	Atk, AtkBonus, Crit, CritDmg, Spd, HP, HPBonus int
+	Atk, AtkBonus, Crit, CritDmg, Spd, HP, HPBonus int `yaml:",omitempty"`
+	Def, DefBonus, EffectHit, EffectRes            int `yaml:",omitempty"`
+	// Stars is the soul's star rating from 1 to 6, or zero if it isn't known, and Level how far it
+	// has been enhanced, from 0 to MaxLevel.
+	Stars, Level int `yaml:",omitempty"`
+	// Main is the soul's main stat, if the soul records it. Its value is also counted in the stats
+	// above, which hold the soul's main stat and substats together.
+	Main Stat `yaml:"-"`
+	// Locked reserves the soul for the shikigami it's equipped on, so it isn't planned for others.
+	Locked bool `yaml:",omitempty"`
 }
 
 func (s Soul) String() string {
-	attrs := make([]string, 0, 5)
+	name := s.Type
+	if s.ID != 0 {
+		name = "#" + strconv.Itoa(s.ID) + " " + name
+	}
+	if s.Stars > 0 {
+		name += " " + strconv.Itoa(s.Stars) + "* +" + strconv.Itoa(s.Level)
+	}
+	return name + " | " + s.statsString()
+}
+
+// statsString lists the stats of the soul that aren't zero.
+func (s Soul) statsString() string {
+	attrs := make([]string, 0, 12)
+	if s.Main.Name != "" {
+		attrs = append(attrs, "Main="+s.Main.Name)
+	}
 	if s.HP > 0 {
 		attrs = append(attrs, "HP="+strconv.Itoa(s.HP))
 	}
@@ -154,184 +81,111 @@ func (s Soul) String() string {
 	if s.Spd > 0 {
 		attrs = append(attrs, "Spd="+strconv.Itoa(s.Spd))
 	}
-	return s.Type + " | " + strings.Join(attrs, ", ")
+	if s.Def > 0 {
+		attrs = append(attrs, "Def="+strconv.Itoa(s.Def))
+	}
+	if s.DefBonus > 0 {
+		attrs = append(attrs, "DefBonus="+strconv.Itoa(s.DefBonus)+"%")
+	}
+	if s.EffectHit > 0 {
+		attrs = append(attrs, "EffectHit="+strconv.Itoa(s.EffectHit)+"%")
+	}
+	if s.EffectRes > 0 {
+		attrs = append(attrs, "EffectRes="+strconv.Itoa(s.EffectRes)+"%")
+	}
+	return strings.Join(attrs, ", ")
 }
 
 // SoulDb represents all your souls.
 type SoulDb struct {
 	Slot1, Slot2, Slot3, Slot4, Slot5, Slot6 []Soul
+	// Loadouts maps a shikigami's name to the ID of the soul it wears in each slot, or zero for an
+	// empty slot.
+	Loadouts map[string][6]int
+	// current holds the souls as they are now by ID, for those that Projected replaced.
+	current map[int]Soul
 }
 
-// Result contains the outcome of applying a soulset to a shikigami.
+// Result contains the outcome of applying a soulset to a shikigami. HP includes shields from set
+// effects, such as Namazu's, so constraints and tie-breaks on HP count them too.
 type Result struct {
-	Damage, Heal, HP, Crit, Spd int
-	Souls                       SoulSet
+	Damage, Heal, HP, Crit, Spd, Atk, CritDmg int
+	Def, EffectHit, EffectRes                 int
+	Souls                                     SoulSet
 }
 
 func (r Result) String() string {
-	return fmt.Sprintf("dmg = %v, heal = %v, hp = %v, speed = %v, crit = %v\n%v", r.Damage, r.Heal, r.HP, r.Spd, r.Crit, r.Souls)
+	return r.format(nil)
 }
 
-func contains(names []string, name string) bool {
-	for _, n := range names {
-		if n == name {
-			return true
-		}
-	}
-	return false
+// format formats the result, adding the note returned for the soul in each slot if note isn't nil.
+func (r Result) format(note func(k int, sl Soul) string) string {
+	return r.Summary() + "\n" + r.Souls.format(note)
 }
 
-// BestSet constructs a SoulSet for each combination of souls in the database. It calls the fitness
-// function on each set that includes at least 4 of the primary soul (if primary is not an empty
-// string). It returns the best set.
-func (db *SoulDb) BestSet(primaries, secondaries []string, opt Optimizer, fn func(SoulSet) Result) Result {
-	candidates := make(chan Result)
-
-	slot1, slot2, slot3 := opt.bestOf(db.Slot1), opt.bestOf(db.Slot2), opt.bestOf(db.Slot3)
-	slot4, slot5, slot6 := opt.bestOf(db.Slot4), opt.bestOf(db.Slot5), opt.bestOf(db.Slot6)
+// Summary formats the attributes of the result without its souls.
+func (r Result) Summary() string {
+	return fmt.Sprintf("dmg = %v, heal = %v, hp = %v, speed = %v, crit = %v, atk = %v, critdmg = %v, def = %v, effect hit = %v, effect res = %v",
+		r.Damage, r.Heal, r.HP, r.Spd, r.Crit, r.Atk, r.CritDmg, r.Def, r.EffectHit, r.EffectRes)
+}
 
-	type setcompletion = int
-	const (
-		partial setcompletion = iota
-		complete
-	)
+func (db *SoulDb) slots() [6]*[]Soul {
+	return [...]*[]Soul{&db.Slot1, &db.Slot2, &db.Slot3, &db.Slot4, &db.Slot5, &db.Slot6}
+}
 
-	match := func(typ, primName string, primCount int, secs *immutable.Map) (string, int, *immutable.Map) {
-		if contains(primaries, typ) {
-			if primName == "" {
-				return typ, 1, secs
-			}
-			if primName != typ {
-				return "", 0, nil
-			}
-			return primName, primCount + 1, secs
+// Remove all souls in the SoulSet from the database. Souls with an ID are found by their ID, and
+// others by their type and stats. If a soul isn't in the database, it removes nothing and returns
+// an error.
+func (db *SoulDb) Remove(set SoulSet) error {
+	slots := db.slots()
+	var found [6]int
+	for k, sl := range set.souls {
+		if found[k] = find(*slots[k], sl); found[k] < 0 {
+			return fmt.Errorf("slot %v has no soul %v", k+1, sl)
 		}
-		if contains(secondaries, typ) || len(secondaries) == 0 {
-			// If matched to secondaries, or no primaries or secondaries were requested, add to secondaries.
-			if val, ok := secs.Get(typ); ok {
-				if val.(setcompletion) == complete {
-					// Don't allow more than two of a secondary.
-					return "", 0, nil
-				}
-				secs = secs.Set(typ, complete)
-			} else {
-				secs = secs.Set(typ, partial)
-			}
+	}
+	for k, slot := range slots {
+		*slot = remove(*slot, found[k])
+	}
+	return nil
+}
 
-			if (len(primaries) > 0 && secs.Len() > 2) || secs.Len() > 4 {
-				// If primary is requested, allow up to 2 secondary. Else allow up to 4 secondaries.
-				return "", 0, nil
+// AssignIDs gives each soul without an ID the next unused ID, in slot order, and returns how many
+// souls it gave IDs to.
+func (db *SoulDb) AssignIDs() int {
+	next := 0
+	for _, slot := range db.slots() {
+		for _, sl := range *slot {
+			if sl.ID > next {
+				next = sl.ID
 			}
-			return primName, primCount, secs
 		}
-		// If primaries or secondaries were requested, then we want to stop if we didn't match either.
-		return "", 0, nil
 	}
-
-	primName, primCount := "", 0
-	secs := immutable.NewMap(nil)
-
-	numCandidates := 0
-	for _, sl1 := range slot1 {
-		primName, primCount, secs := match(sl1.Type, primName, primCount, secs)
-		if secs == nil {
-			continue
-		}
-		numCandidates++
-
-		go func(sl1 Soul) {
-			var best Result
-			for _, sl2 := range slot2 {
-				primName, primCount, secs := match(sl2.Type, primName, primCount, secs)
-				if secs == nil {
-					continue
-				}
-
-				for _, sl3 := range slot3 {
-					primName, primCount, secs := match(sl3.Type, primName, primCount, secs)
-					if secs == nil {
-						continue
-					}
-					// Starting once we have 3 souls, test that we have sufficient copies of the primary soul
-					// type to complete a set of 4. If not, skip this set of combinations.
-					if len(primaries) > 0 && primCount < 1 {
-						continue
-					}
-
-					for _, sl4 := range slot4 {
-						primName, primCount, secs := match(sl4.Type, primName, primCount, secs)
-						if secs == nil {
-							continue
-						}
-						if len(primaries) > 0 && primCount < 2 {
-							continue
-						}
-
-						for _, sl5 := range slot5 {
-							primName, primCount, secs := match(sl5.Type, primName, primCount, secs)
-							if secs == nil {
-								continue
-							}
-							if len(primaries) > 0 && primCount < 3 {
-								continue
-							}
-							// If we haven't found enough secondaries yet, skip.
-							if primName != "" {
-								if secs.Len() < 1 {
-									continue
-								}
-							} else if secs.Len() < 3 {
-								continue
-							}
-
-							for _, sl6 := range slot6 {
-								_, primCount, secs := match(sl6.Type, primName, primCount, secs)
-								if secs == nil {
-									continue
-								}
-								if len(primaries) > 0 && primCount < 4 {
-									continue
-								}
-
-								r := fn(NewSoulSet([6]Soul{sl1, sl2, sl3, sl4, sl5, sl6}))
-								if (opt == Damage && r.Damage > best.Damage) ||
-									(opt == Heal && r.Heal > best.Heal) ||
-									(opt == HP && r.HP > best.HP) {
-									best = r
-								}
-							}
-						}
-					}
-				}
+	assigned := 0
+	for _, slot := range db.slots() {
+		for i := range *slot {
+			if (*slot)[i].ID == 0 {
+				next++
+				(*slot)[i].ID = next
+				assigned++
 			}
-			candidates <- best
-		}(sl1)
-	}
-
-	var best Result
-	for i := 0; i < numCandidates; i++ {
-		r := <-candidates
-		if r.Damage > best.Damage {
-			best = r
 		}
 	}
-	close(candidates)
-	return best
+	return assigned
 }
 
-// Remove all souls in the SoulSet from the database.
-func (db *SoulDb) Remove(set SoulSet) {
-	db.Slot1 = removeFirst(db.Slot1, set.souls[0])
-	db.Slot2 = removeFirst(db.Slot2, set.souls[1])
-	db.Slot3 = removeFirst(db.Slot3, set.souls[2])
-	db.Slot4 = removeFirst(db.Slot4, set.souls[3])
-	db.Slot5 = removeFirst(db.Slot5, set.souls[4])
-	db.Slot6 = removeFirst(db.Slot6, set.souls[5])
-}
-
-func removeFirst(s []Soul, x Soul) []Soul {
-	i := find(s, x)
-	return remove(s, i)
+// CheckIDs returns an error if two souls share an ID.
+func (db *SoulDb) CheckIDs() error {
+	seen := make(map[int]bool)
+	for _, slot := range db.slots() {
+		for _, sl := range *slot {
+			if sl.ID != 0 && seen[sl.ID] {
+				return fmt.Errorf("more than one soul has ID %v", sl.ID)
+			}
+			seen[sl.ID] = true
+		}
+	}
+	return nil
 }
 
 func remove(s []Soul, i int) []Soul {
@@ -339,32 +193,58 @@ func remove(s []Soul, i int) []Soul {
 	return s[:len(s)-1]
 }
 
+// find returns the index of x in a, matching by ID if x has one, or -1 if a doesn't hold x.
 func find(a []Soul, x Soul) int {
 	for i, n := range a {
-		if x == n {
+		if (x.ID != 0 && x.ID == n.ID) || x == n {
 			return i
 		}
 	}
-	return len(a)
+	return -1
 }
 
 // SoulSet represents a set of 6 souls, slots 1-6.
 type SoulSet struct {
 	souls  [6]Soul
-	counts map[string]int
+	counts kindCounts
+	// total holds the sum of each stat of the souls.
+	total Soul
 }
 
 // NewSoulSet constructs a new soul set and computes counts of different soul types.
 func NewSoulSet(souls [6]Soul) SoulSet {
-	return SoulSet{souls: souls, counts: soulCounts(souls[:])}
+	var kinds [6]kind
+	for i, sl := range souls {
+		kinds[i] = internKind(sl.Type)
+	}
+	return newSoulSet(souls, kinds)
 }
 
-func soulCounts(soulSet []Soul) map[string]int {
-	counts := make(map[string]int)
-	for _, sl := range soulSet {
-		counts[strings.ToLower(sl.Type)]++
+// newSoulSet constructs a soul set from souls whose types have already been interned.
+func newSoulSet(souls [6]Soul, kinds [6]kind) SoulSet {
+	set := SoulSet{souls: souls}
+	for i, sl := range souls {
+		set.counts.add(kinds[i])
+		set.total = addStats(set.total, sl)
+	}
+	return set
+}
+
+// addStats returns a soul with each stat of a and b added together.
+func addStats(a, b Soul) Soul {
+	return Soul{
+		Atk:       a.Atk + b.Atk,
+		AtkBonus:  a.AtkBonus + b.AtkBonus,
+		Crit:      a.Crit + b.Crit,
+		CritDmg:   a.CritDmg + b.CritDmg,
+		Spd:       a.Spd + b.Spd,
+		HP:        a.HP + b.HP,
+		HPBonus:   a.HPBonus + b.HPBonus,
+		Def:       a.Def + b.Def,
+		DefBonus:  a.DefBonus + b.DefBonus,
+		EffectHit: a.EffectHit + b.EffectHit,
+		EffectRes: a.EffectRes + b.EffectRes,
 	}
-	return counts
 }
 
 // Empty returns true if the set has no souls.
@@ -377,9 +257,22 @@ func (set SoulSet) Souls() [6]Soul {
 	return set.souls
 }
 
+// IDs returns the ID of the soul in each slot.
+func (set SoulSet) IDs() [6]int {
+	var ids [6]int
+	for k, sl := range set.souls {
+		ids[k] = sl.ID
+	}
+	return ids
+}
+
 // Count returns the count of a particular soul type in the set.
 func (set SoulSet) Count(name string) int {
-	return set.counts[strings.ToLower(name)]
+	k, ok := lookupKind(name)
+	if !ok {
+		return 0
+	}
+	return set.counts.count(k)
 }
 
 // DamageOptions is used to pass options that change how damage is calculated.
@@ -390,18 +283,8 @@ type DamageOptions struct {
 
 // ComputeCrit returns the critical hit chance of the shikigami with this soul set.
 func (set SoulSet) ComputeCrit(shiki Shikigami, critMod int) int {
-	crit := shiki.Crit + critMod
-	for _, sl := range set.Souls() {
-		crit += sl.Crit
-	}
-
-	critSouls := 0
-	for name, attr := range soulTypes {
-		if attr == "crit" && set.Count(name) >= 2 {
-			critSouls++
-		}
-	}
-	crit += 15 * critSouls
+	crit := shiki.Crit + critMod + set.total.Crit
+	crit += 15 * set.counts.pairs(critBonus)
 
 	if crit > 100 {
 		return 100
@@ -411,96 +294,104 @@ func (set SoulSet) ComputeCrit(shiki Shikigami, critMod int) int {
 	return crit
 }
 
-// Damage computes the shikigami's damage output with this soul set.
-func (set SoulSet) Damage(shiki Shikigami, mod Modifiers, opts DamageOptions) int {
+func (set SoulSet) attack(shiki Shikigami, mod Modifiers) float64 {
 	// soul and shikigami numbers are stored as ints to simplify input. Convert to percentages here.
-	atkbonus := 1.0 + float64(mod.AtkBonus)/100.0
-	for _, sl := range set.Souls() {
-		atkbonus += float64(sl.AtkBonus) / 100.0
-	}
+	atkbonus := 1.0 + float64(mod.AtkBonus+set.total.AtkBonus)/100.0
+	atkbonus += 0.15 * float64(set.counts.pairs(atkBonus))
 
-	atkSouls := 0
-	for name, attr := range soulTypes {
-		if attr == "atk bonus" && set.Count(name) >= 2 {
-			atkSouls++
-		}
-	}
-	atkbonus += 0.15 * float64(atkSouls)
+	return float64(shiki.Atk+mod.Atk)*atkbonus + float64(set.total.Atk)
+}
 
-	atk := float64(shiki.Atk+mod.Atk) * atkbonus
-	for _, sl := range set.Souls() {
-		atk += float64(sl.Atk)
-	}
+// ComputeAtk returns the attack of the shikigami with this soul set.
+func (set SoulSet) ComputeAtk(shiki Shikigami, mod Modifiers) int {
+	return int(set.attack(shiki, mod))
+}
 
-	crit := float64(set.ComputeCrit(shiki, mod.Crit)) / 100.0
+func (set SoulSet) critDamage(shiki Shikigami, critDmgMod int) float64 {
+	return float64(set.ComputeCritDmg(shiki, critDmgMod)) / 100.0
+}
 
-	critDmg := float64(shiki.CritDmg+mod.CritDmg) / 100.0
-	for _, sl := range set.Souls() {
-		critDmg += float64(sl.CritDmg) / 100.0
-	}
+// ComputeCritDmg returns the critical damage of the shikigami with this soul set, as a percentage.
+func (set SoulSet) ComputeCritDmg(shiki Shikigami, critDmgMod int) int {
+	return shiki.CritDmg + critDmgMod + set.total.CritDmg
+}
+
+// Damage computes the shikigami's damage output with this soul set.
+func (set SoulSet) Damage(shiki Shikigami, mod Modifiers, opts DamageOptions) int {
+	atk := set.attack(shiki, mod)
+	crit := float64(set.ComputeCrit(shiki, mod.Crit)) / 100.0
+	critDmg := set.critDamage(shiki, mod.CritDmg)
 
 	dmg := atk * (crit*critDmg + (1.0 - crit))
 	if !opts.IgnoreSetBonus {
-		if set.Count("Odokuro") >= 2 {
-			dmg *= 1.1
-		}
-		if shiki.Multihit && set.Count("Ghostly Songstress") >= 2 {
-			// Every 6th hit deals extra 255% of Atk (up to 20% of target's max HP).
-			dmg += (2.55 * atk) / 6
-		}
-		if set.Count("Seductress") >= 4 {
-			dmg += 1.2 * crit * atk
-		} else if set.Count("Shadow") >= 4 || set.Count("Watcher") >= 4 {
-			dmg *= 1.4
-		} else if set.Count("Kyoukotsu") >= 4 {
-			dmg *= (1.0 + 0.08*float64(opts.Orbs))
-		}
+		// Damage increases apply to the attack, and extra damage is added after them.
+		_, increase := set.effects(DamageEffect, shiki, crit, opts.Orbs)
+		extra, _ := set.effects(ExtraDamageEffect, shiki, crit, opts.Orbs)
+		dmg = dmg*increase + extra*atk
 	}
 	return int(dmg)
 }
 
-// Heal returns the healing prowess of the shikigami, evaluated as HP * Crit * CritDmg
+// Heal returns the healing prowess of the shikigami, evaluated as max HP * Crit * CritDmg and
+// scaled by any healing set effects.
 func (set SoulSet) Heal(shiki Shikigami, mod Modifiers) int {
-	hp := set.HP(shiki, mod)
+	hp := set.maxHP(shiki, mod)
 
 	crit := float64(set.ComputeCrit(shiki, mod.Crit)) / 100.0
+	critDmg := float64(shiki.CritDmg+set.total.CritDmg) / 100.0
 
-	critDmg := float64(shiki.CritDmg) / 100.0
-	for _, sl := range set.Souls() {
-		critDmg += float64(sl.CritDmg) / 100.0
-	}
-
-	heal := float64(hp) * (crit*critDmg + (1.0 - crit))
+	_, increase := set.effects(HealEffect, shiki, crit, 0)
+	heal := float64(hp) * (crit*critDmg + (1.0 - crit)) * increase
 	return int(heal)
 }
 
-// HP returns the shikigami's HP with this soul set.
+// HP returns the shikigami's HP with this soul set, counting shields from set effects.
 func (set SoulSet) HP(shiki Shikigami, mod Modifiers) int {
+	hp := set.maxHP(shiki, mod)
+	shield, _ := set.effects(ShieldEffect, shiki, 0, 0)
+	return hp + int(shield*float64(hp))
+}
+
+// maxHP returns the shikigami's max HP with this soul set.
+func (set SoulSet) maxHP(shiki Shikigami, mod Modifiers) int {
 	// soul and shikigami numbers are stored as ints to simplify input. Convert to percentages here.
-	hpbonus := 1.0 + float64(mod.HPBonus)/100.0
-	for _, sl := range set.Souls() {
-		hpbonus += float64(sl.HPBonus) / 100.0
-	}
+	hpbonus := 1.0 + float64(mod.HPBonus+set.total.HPBonus)/100.0
+	hpbonus += 0.15 * float64(set.counts.pairs(hpBonus))
 
-	hpSouls := 0
-	for name, attr := range soulTypes {
-		if attr == "hp bonus" && set.Count(name) >= 2 {
-			hpSouls++
-		}
-	}
-	hpbonus += 0.15 * float64(hpSouls)
+	return int(float64(shiki.HP)*hpbonus + float64(set.total.HP))
+}
 
-	hp := float64(shiki.HP) * hpbonus
-	for _, sl := range set.Souls() {
-		hp += float64(sl.HP)
-	}
-	return int(hp)
+// ComputeDef returns the defense of the shikigami with this soul set.
+func (set SoulSet) ComputeDef(shiki Shikigami, mod Modifiers) int {
+	// soul and shikigami numbers are stored as ints to simplify input. Convert to percentages here.
+	defbonus := 1.0 + float64(mod.DefBonus+set.total.DefBonus)/100.0
+	defbonus += 0.15 * float64(set.counts.pairs(defBonus))
+
+	return int(float64(shiki.Def+mod.Def)*defbonus + float64(set.total.Def))
+}
+
+// ComputeEffectHit returns the effect hit of the shikigami with this soul set, as a percentage.
+func (set SoulSet) ComputeEffectHit(shiki Shikigami, effectHitMod int) int {
+	return shiki.EffectHit + effectHitMod + set.total.EffectHit + 15*set.counts.pairs(effectHitBonus)
+}
+
+// ComputeEffectRes returns the effect resist of the shikigami with this soul set, as a percentage.
+func (set SoulSet) ComputeEffectRes(shiki Shikigami, effectResMod int) int {
+	return shiki.EffectRes + effectResMod + set.total.EffectRes
 }
 
 func (set SoulSet) String() string {
+	return set.format(nil)
+}
+
+func (set SoulSet) format(note func(k int, sl Soul) string) string {
 	var out string
 	for i, soul := range set.souls {
-		out += "Slot " + strconv.Itoa(i+1) + ": " + soul.String() + "\n"
+		out += "Slot " + strconv.Itoa(i+1) + ": " + soul.String()
+		if note != nil {
+			out += note(i, soul)
+		}
+		out += "\n"
 	}
 	return out
 }
@@ -508,4 +399,29 @@ func (set SoulSet) String() string {
 // Modifiers contains modifications to specific stats.
 type Modifiers struct {
 	Crit, CritDmg, Atk, AtkBonus, HPBonus int
+	Def, DefBonus, EffectHit, EffectRes   int
+}
+
+// Evaluator computes how a shikigami performs with a soul set.
+type Evaluator struct {
+	Shikigami Shikigami
+	Modifiers Modifiers
+	Options   DamageOptions
+}
+
+// Evaluate returns the Result of equipping the shikigami with the soul set.
+func (e Evaluator) Evaluate(set SoulSet) Result {
+	return Result{
+		Damage:    set.Damage(e.Shikigami, e.Modifiers, e.Options),
+		Heal:      set.Heal(e.Shikigami, e.Modifiers),
+		HP:        set.HP(e.Shikigami, e.Modifiers),
+		Crit:      set.ComputeCrit(e.Shikigami, e.Modifiers.Crit),
+		Spd:       e.Shikigami.Spd + set.total.Spd,
+		Atk:       set.ComputeAtk(e.Shikigami, e.Modifiers),
+		CritDmg:   set.ComputeCritDmg(e.Shikigami, e.Modifiers.CritDmg),
+		Def:       set.ComputeDef(e.Shikigami, e.Modifiers),
+		EffectHit: set.ComputeEffectHit(e.Shikigami, e.Modifiers.EffectHit),
+		EffectRes: set.ComputeEffectRes(e.Shikigami, e.Modifiers.EffectRes),
+		Souls:     set,
+	}
 }
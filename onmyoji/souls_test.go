@@ -0,0 +1,411 @@
+package onmyoji
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testTypes = []string{"Seductress", "Shadow", "Watcher", "Odokuro", "Nightwing", "Samisen", "Priestess", "Namazu"}
+
+// randomSoul rolls a soul with the main stat of its slot and a few substats.
+func randomSoul(rng *rand.Rand, slot int) Soul {
+	sl := Soul{Type: testTypes[rng.Intn(len(testTypes))]}
+	switch slot {
+	case 0:
+		sl.Atk = 486
+	case 2:
+		// Slot 3 has a defense main stat, which doesn't matter to the planner.
+	case 4:
+		sl.HP = 2052
+	default:
+		switch rng.Intn(5) {
+		case 0:
+			sl.AtkBonus = 55
+		case 1:
+			sl.Crit = 55
+		case 2:
+			sl.CritDmg = 89
+		case 3:
+			sl.Spd = 57
+		case 4:
+			sl.HPBonus = 55
+		}
+	}
+
+	for i := rng.Intn(5); i > 0; i-- {
+		switch rng.Intn(5) {
+		case 0:
+			sl.AtkBonus += 3 + rng.Intn(13)
+		case 1:
+			sl.Crit += 2 + rng.Intn(15)
+		case 2:
+			sl.CritDmg += 3 + rng.Intn(18)
+		case 3:
+			sl.Spd += 2 + rng.Intn(16)
+		case 4:
+			sl.HPBonus += 3 + rng.Intn(13)
+		}
+	}
+	return sl
+}
+
+func randomDb(seed int64, perSlot int) SoulDb {
+	rng := rand.New(rand.NewSource(seed))
+	var slots [6][]Soul
+	for k := range slots {
+		for i := 0; i < perSlot; i++ {
+			slots[k] = append(slots[k], randomSoul(rng, k))
+		}
+	}
+	return SoulDb{Slot1: slots[0], Slot2: slots[1], Slot3: slots[2], Slot4: slots[3], Slot5: slots[4], Slot6: slots[5]}
+}
+
+// withShadow returns whether a search with a Shadow primary can find the set: it needs 4 Shadow
+// souls and at least one other soul in slots 1-5.
+func withShadow(set SoulSet) bool {
+	souls := set.Souls()
+	for _, sl := range souls[:5] {
+		if sl.Type != "Shadow" {
+			return set.Count("Shadow") >= 4
+		}
+	}
+	return false
+}
+
+// withPairs returns whether a search without a primary can find the set: it allows pairs of up to
+// 4 soul types.
+func withPairs(set SoulSet) bool {
+	for _, n := range set.counts.n[:set.counts.len] {
+		if n > 2 {
+			return false
+		}
+	}
+	return set.counts.len <= 4
+}
+
+// exhaustiveResults checks every combination in the database, accepting sets that valid returns
+// true for. It returns each distinct acceptable set, from best to worst.
+func exhaustiveResults(db SoulDb, opt Optimizer, ev Evaluator, valid func(SoulSet) bool, accept func(Result) bool) []Result {
+	seen := make(map[[6]Soul]struct{})
+	var results []Result
+	for _, s1 := range db.Slot1 {
+		for _, s2 := range db.Slot2 {
+			for _, s3 := range db.Slot3 {
+				for _, s4 := range db.Slot4 {
+					for _, s5 := range db.Slot5 {
+						for _, s6 := range db.Slot6 {
+							set := NewSoulSet([6]Soul{s1, s2, s3, s4, s5, s6})
+							if _, ok := seen[set.souls]; ok || !valid(set) {
+								continue
+							}
+							seen[set.souls] = struct{}{}
+							if r := ev.Evaluate(set); accept(r) {
+								results = append(results, r)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return opt.Value(results[i]) > opt.Value(results[j]) })
+	return results
+}
+
+func exhaustiveValues(db SoulDb, opt Optimizer, ev Evaluator, valid func(SoulSet) bool, accept func(Result) bool) []float64 {
+	return values(opt, exhaustiveResults(db, opt, ev, valid, accept))
+}
+
+func head(values []float64, n int) []float64 {
+	if len(values) < n {
+		n = len(values)
+	}
+	return append([]float64{}, values[:n]...)
+}
+
+func nonEmpty(results ...Result) []Result {
+	var nonEmpty []Result
+	for _, r := range results {
+		if !r.Souls.Empty() {
+			nonEmpty = append(nonEmpty, r)
+		}
+	}
+	return nonEmpty
+}
+
+func values(opt Optimizer, results []Result) []float64 {
+	values := make([]float64, len(results))
+	for i, r := range results {
+		values[i] = opt.Value(r)
+	}
+	return values
+}
+
+func TestBestSetsMatchExhaustive(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	ev := Evaluator{Shikigami: shiki, Options: DamageOptions{Orbs: 5}}
+
+	any := func(Result) bool { return true }
+	spd := func(r Result) bool { return r.Spd >= 150 && r.Spd <= 160 }
+	critHP := func(r Result) bool { return r.Crit >= 50 && r.HP <= 12000 }
+	// Each filter is given to the search either as Accept or, if set, as Constraints.
+	filters := []struct {
+		accept      func(Result) bool
+		constraints []Constraint
+	}{
+		{any, nil},
+		{spd, nil},
+		{spd, []Constraint{{Attribute: "spd", Min: 150, Max: 160}}},
+		{critHP, []Constraint{{Attribute: "crit", Min: 50}, {Attribute: "HP", Max: 12000}}},
+	}
+
+	for seed := int64(1); seed <= 3; seed++ {
+		db := randomDb(seed, 6)
+		for _, opt := range []Optimizer{Damage, HP, Heal, "0.7*dmg + 0.3*hp + 50*spd", "heal - 50*crit"} {
+			for _, filter := range filters {
+				accept := filter.accept
+				query := func(primaries ...string) Query {
+					// Vary the number of workers, which shouldn't change the results.
+					q := Query{Primaries: primaries, Optimize: opt, Evaluator: ev, Constraints: filter.constraints, Workers: int(seed)}
+					if q.Constraints == nil {
+						q.Accept = accept
+					}
+					return q
+				}
+
+				expected := exhaustiveValues(db, opt, ev, withShadow, accept)
+				actual := db.BestSet(query("Shadow"))
+				assert.Equal(t, head(expected, 1), values(opt, nonEmpty(actual)), "seed %v, %v", seed, opt)
+
+				top := db.BestSets(5, query("Shadow"))
+				assert.Equal(t, head(expected, 5), values(opt, top), "seed %v, %v", seed, opt)
+
+				expected = exhaustiveValues(db, opt, ev, withPairs, accept)
+				actual = db.BestSet(query())
+				assert.Equal(t, head(expected, 1), values(opt, nonEmpty(actual)), "seed %v, %v", seed, opt)
+
+				top = db.BestSets(5, query())
+				assert.Equal(t, head(expected, 5), values(opt, top), "seed %v, %v", seed, opt)
+			}
+		}
+	}
+}
+
+func TestBestSetsContextStops(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	q := Query{Primaries: []string{"Shadow"}, Optimize: Damage, Evaluator: Evaluator{Shikigami: shiki}}
+	db := randomDb(2, 6)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, stats, err := db.BestSetsContext(ctx, 1, q)
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, stats.Covered < 1)
+
+	results, stats, err := db.BestSetsContext(context.Background(), 1, q)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, stats.Covered)
+	assert.Equal(t, nonEmpty(db.BestSet(q)), results)
+	assert.NotEmpty(t, results)
+	assert.True(t, stats.Evaluated > 0 && stats.Completed >= stats.Evaluated)
+	assert.True(t, stats.Elapsed > 0)
+}
+
+func TestInvalidQueriesReturnErrors(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	ev := Evaluator{Shikigami: shiki}
+	db := randomDb(2, 3)
+
+	for _, q := range []Query{
+		{Evaluator: ev},
+		{Optimize: Optimizer("atk +"), Evaluator: ev},
+		{Optimize: Optimizer("nothing"), Evaluator: ev},
+		{Optimize: Damage, Evaluator: ev, Constraints: []Constraint{{Attribute: "luck", Min: 1}}},
+	} {
+		results, _, err := db.BestSetsContext(context.Background(), 1, q)
+		assert.Error(t, err, "%+v", q)
+		assert.Empty(t, results)
+		assert.Empty(t, db.BestSets(1, q))
+		assert.False(t, q.Allows(db.BestSet(Query{Optimize: Damage, Evaluator: ev})))
+
+		_, err = db.BestTeam([]Member{{Name: "Onikiri", Query: q, Weight: 1}})
+		assert.Error(t, err)
+		_, err = db.GreedyTeam([]Member{{Name: "Onikiri", Query: q}})
+		assert.Error(t, err)
+	}
+}
+
+func TestBestSetsAgreeOnTies(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	q := Query{Optimize: Damage, Evaluator: Evaluator{Shikigami: shiki}}
+
+	// Souls that only differ in stats the objective ignores still decide which set comes first.
+	odokuro := Soul{Type: "Odokuro", Crit: 10}
+	db := SoulDb{
+		Slot1: []Soul{{Type: "Odokuro", Atk: 486}, {Type: "Odokuro", Atk: 486}, {Type: "Odokuro", Atk: 486, HP: 100}},
+		Slot2: []Soul{odokuro}, Slot3: []Soul{odokuro}, Slot4: []Soul{odokuro}, Slot5: []Soul{odokuro}, Slot6: []Soul{odokuro},
+	}
+	db.AssignIDs()
+	free := q
+	free.Layout = "free"
+	assert.Equal(t, 100, db.BestSet(free).Souls.Souls()[0].HP)
+	assert.Equal(t, db.BestSet(free), db.BestSets(4, free)[0])
+
+	for seed := int64(1); seed <= 3; seed++ {
+		db := randomDb(seed, 4)
+		for _, slot := range db.slots() {
+			sl := (*slot)[0]
+			sl.HP += 100
+			sl.Def += 10
+			*slot = append(*slot, (*slot)[0], sl)
+		}
+		db.AssignIDs()
+		best := db.BestSet(q)
+		for _, n := range []int{2, 4, 8} {
+			assert.Equal(t, best, db.BestSets(n, q)[0], "seed %v, %v sets", seed, n)
+		}
+	}
+}
+
+func TestBestSetsStats(t *testing.T) {
+	shiki, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	db := randomDb(2, 6)
+	q := Query{
+		Optimize:    Damage,
+		Evaluator:   Evaluator{Shikigami: shiki},
+		Constraints: []Constraint{{Attribute: "crit", Min: 60}},
+		Accept:      func(r Result) bool { return false },
+	}
+	results, stats, err := db.BestSetsContext(context.Background(), 1, q)
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+
+	// Nothing is accepted, so every set evaluated was rejected by the constraint or by Accept.
+	assert.Len(t, stats.Rejected, 1)
+	assert.True(t, stats.Evaluated > 0, "%+v", stats)
+	assert.True(t, stats.RejectedByAccept > 0, "%+v", stats)
+	assert.Equal(t, stats.Evaluated, stats.Rejected[0]+stats.RejectedByAccept)
+	assert.True(t, stats.Completed >= stats.Evaluated)
+}
+
+func TestSoulIDs(t *testing.T) {
+	shadow := Soul{Type: "Shadow", Crit: 10}
+	db := SoulDb{
+		Slot1: []Soul{shadow, shadow}, Slot2: []Soul{{ID: 7, Type: "Shadow"}}, Slot3: []Soul{shadow},
+		Slot4: []Soul{shadow}, Slot5: []Soul{shadow}, Slot6: []Soul{shadow},
+	}
+	assert.Equal(t, 6, db.AssignIDs())
+	assert.Equal(t, 0, db.AssignIDs())
+	assert.Equal(t, []int{8, 9}, []int{db.Slot1[0].ID, db.Slot1[1].ID})
+	assert.Equal(t, 7, db.Slot2[0].ID)
+	assert.NoError(t, db.CheckIDs())
+
+	// Identical souls are told apart by their IDs.
+	set := NewSoulSet([6]Soul{db.Slot1[1], db.Slot2[0], db.Slot3[0], db.Slot4[0], db.Slot5[0], db.Slot6[0]})
+	assert.Equal(t, [6]int{9, 7, 10, 11, 12, 13}, set.IDs())
+	assert.NoError(t, db.Remove(set))
+	assert.Equal(t, []Soul{db.Slot1[0]}, db.Slot1)
+	assert.Equal(t, 8, db.Slot1[0].ID)
+	assert.Empty(t, db.Slot2)
+
+	// Removing souls that are gone leaves the database alone.
+	err := db.Remove(set)
+	assert.Error(t, err)
+	assert.Len(t, db.Slot1, 1)
+
+	db.Slot2 = []Soul{{ID: 8, Type: "Shadow"}}
+	assert.Error(t, db.CheckIDs())
+}
+
+func BenchmarkBestSet(b *testing.B) {
+	shiki, _ := GetShikigami("Onikiri")
+	ev := Evaluator{Shikigami: shiki, Options: DamageOptions{Orbs: 5}}
+	db := randomDb(1, 40)
+	for i := 0; i < b.N; i++ {
+		db.BestSet(Query{Primaries: []string{"Seductress"}, Optimize: Damage, Evaluator: ev})
+	}
+}
+
+func BenchmarkBestSetSpeedTune(b *testing.B) {
+	shiki, _ := GetShikigami("Onikiri")
+	ev := Evaluator{Shikigami: shiki, Options: DamageOptions{Orbs: 5}}
+	db := randomDb(1, 40)
+	b.Run("accept", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			db.BestSet(Query{Primaries: []string{"Seductress"}, Optimize: Damage, Evaluator: ev, Accept: func(r Result) bool {
+				return r.Spd >= 200 && r.Spd <= 203
+			}})
+		}
+	})
+	b.Run("constraints", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			db.BestSet(Query{Primaries: []string{"Seductress"}, Optimize: Damage, Evaluator: ev, Constraints: []Constraint{{Attribute: "spd", Min: 200, Max: 203}}})
+		}
+	})
+}
+
+// BenchmarkBestSetWorkers compares searching with different numbers of workers. The "default"
+// run uses one worker per GOMAXPROCS, so running it with -cpu=1,4,8 shows how searches scale.
+func BenchmarkBestSetWorkers(b *testing.B) {
+	shiki, _ := GetShikigami("Onikiri")
+	ev := Evaluator{Shikigami: shiki, Options: DamageOptions{Orbs: 5}}
+	db := randomDb(1, 40)
+	b.Run("default", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			db.BestSet(Query{Primaries: []string{"Seductress"}, Optimize: Damage, Evaluator: ev})
+		}
+	})
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(strconv.Itoa(workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				db.BestSet(Query{Primaries: []string{"Seductress"}, Optimize: Damage, Evaluator: ev, Workers: workers})
+			}
+		})
+	}
+}
+
+// BenchmarkBestSetLargeDb searches a database with 100 souls in each slot on one worker per
+// GOMAXPROCS. Run it with -cpu=1,4,8 to see how searches of large databases scale.
+func BenchmarkBestSetLargeDb(b *testing.B) {
+	shiki, _ := GetShikigami("Onikiri")
+	ev := Evaluator{Shikigami: shiki, Options: DamageOptions{Orbs: 5}}
+	db := randomDb(1, 100)
+	for i := 0; i < b.N; i++ {
+		db.BestSet(Query{Primaries: []string{"Seductress"}, Optimize: Damage, Evaluator: ev})
+	}
+}
+
+func TestDefenseAndEffectStats(t *testing.T) {
+	shiki := Shikigami{Def: 400, EffectHit: 5, EffectRes: 10}
+	set := NewSoulSet([6]Soul{
+		{Type: "Fortune Cat", Def: 50}, {Type: "Fortune Cat", DefBonus: 55},
+		{Type: "Azure Basan", Def: 50}, {Type: "Azure Basan", EffectHit: 55},
+		{Type: "Namazu", EffectRes: 55}, {Type: "Namazu", EffectHit: 4, EffectRes: 3},
+	})
+	ev := Evaluator{Shikigami: shiki, Modifiers: Modifiers{DefBonus: 10, EffectRes: 2}}
+	r := ev.Evaluate(set)
+	// Def is 400 * (1 + 65% + 15% from the Fortune Cat pair) + 100, less rounding.
+	assert.InDelta(t, 820, r.Def, 1)
+	assert.Equal(t, 5+55+4+15, r.EffectHit)
+	assert.Equal(t, 10+2+55+3, r.EffectRes)
+	assert.Contains(t, set.Souls()[1].String(), "DefBonus=55%")
+
+	// The search can aim for an effect hit threshold.
+	db := randomDb(1, 4)
+	db.Slot2 = append(db.Slot2, Soul{Type: "Azure Basan", EffectHit: 55})
+	db.Slot4 = append(db.Slot4, Soul{Type: "Azure Basan", EffectHit: 55})
+	q := Query{Optimize: Damage, Evaluator: ev, Constraints: []Constraint{{Attribute: "effecthit", Min: 120}}}
+	best := db.BestSet(q)
+	assert.Equal(t, 130, best.EffectHit)
+	assert.Equal(t, 2, best.Souls.Count("Azure Basan"))
+}
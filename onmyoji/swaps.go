@@ -0,0 +1,141 @@
+package onmyoji
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Move is a single in-game action: equipping a soul on a shikigami. The game takes the soul off the
+// shikigami wearing it, if any, and puts the soul it replaces back in the inventory.
+type Move struct {
+	// Slot is the soul's slot, from 0 to 5.
+	Slot int
+	Soul Soul
+	// To is the shikigami to equip the soul on, and From the shikigami wearing it, if any.
+	To, From string
+	// Strips is true if From isn't being given a new soul for the slot, so it's left without one.
+	Strips bool
+}
+
+func (m Move) String() string {
+	if m.From == "" {
+		return fmt.Sprintf("equip slot %v %v on %v", m.Slot+1, describeSoul(m.Soul), m.To)
+	}
+	out := fmt.Sprintf("unequip slot %v %v from %v and equip it on %v", m.Slot+1, describeSoul(m.Soul), m.From, m.To)
+	if m.Strips {
+		out += fmt.Sprintf(", leaving %v without a slot %v soul", m.From, m.Slot+1)
+	}
+	return out
+}
+
+// describeSoul names a soul by its ID and type.
+func describeSoul(sl Soul) string {
+	if sl.ID == 0 {
+		return sl.Type
+	}
+	return fmt.Sprintf("#%v %v", sl.ID, sl.Type)
+}
+
+// Conflict describes a soul that can't be given to every shikigami that was planned to have it.
+type Conflict struct {
+	Slot int
+	Soul Soul
+	// Wanted lists the shikigami planned to wear the soul.
+	Wanted []string
+	// LockedOn is the shikigami the soul is locked to, if it isn't one that was planned to wear it.
+	LockedOn string
+}
+
+func (c Conflict) String() string {
+	if c.LockedOn != "" {
+		return fmt.Sprintf("slot %v %v is locked to %v, but was planned for %v", c.Slot+1, describeSoul(c.Soul), c.LockedOn, strings.Join(c.Wanted, " and "))
+	}
+	return fmt.Sprintf("slot %v %v was planned for both %v", c.Slot+1, describeSoul(c.Soul), strings.Join(c.Wanted, " and "))
+}
+
+// SwapPlan lists the moves that give each shikigami its planned souls.
+type SwapPlan struct {
+	// Moves are ordered by shikigami and then slot. Each soul a shikigami already wears is left on
+	// it, so every move is needed.
+	Moves []Move
+	// Conflicts lists souls that couldn't be moved. Moves only give such a soul to the first
+	// shikigami that wanted it, and don't take locked souls from other shikigami.
+	Conflicts []Conflict
+}
+
+// Swaps plans how to change the souls each named shikigami wears, as recorded in Loadouts, to the
+// soul set at the same index of sets. Each move takes the soul from whoever wears it after the
+// moves before it.
+func (db *SoulDb) Swaps(names []string, sets []SoulSet) SwapPlan {
+	var plan SwapPlan
+	planned := func(name string) bool {
+		for _, n := range names {
+			if strings.EqualFold(n, name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// wearers holds who wears each soul with an ID, and worn what each shikigami wears, as the
+	// moves are made.
+	type slotID struct{ slot, id int }
+	wearers := make(map[slotID]string)
+	worn := make(map[string][6]int)
+	for wearer, ids := range db.Loadouts {
+		worn[strings.ToLower(wearer)] = ids
+		for k, id := range ids {
+			if id != 0 {
+				wearers[slotID{k, id}] = wearer
+			}
+		}
+	}
+
+	type slotSoul struct {
+		slot int
+		soul Soul
+	}
+	conflicts := make(map[slotSoul]int)
+	given := make(map[slotSoul]string)
+	for i, name := range names {
+		for k, sl := range sets[i].souls {
+			key := slotSoul{k, sl}
+			if first, ok := given[key]; ok {
+				if c, ok := conflicts[key]; ok {
+					plan.Conflicts[c].Wanted = append(plan.Conflicts[c].Wanted, name)
+				} else {
+					conflicts[key] = len(plan.Conflicts)
+					plan.Conflicts = append(plan.Conflicts, Conflict{Slot: k, Soul: sl, Wanted: []string{first, name}})
+				}
+				continue
+			}
+			given[key] = name
+
+			ids := worn[strings.ToLower(name)]
+			if sl.ID != 0 && ids[k] == sl.ID {
+				// Already equipped.
+				continue
+			}
+			from := wearers[slotID{k, sl.ID}]
+			if sl.Locked && from != "" {
+				plan.Conflicts = append(plan.Conflicts, Conflict{Slot: k, Soul: sl, Wanted: []string{name}, LockedOn: from})
+				continue
+			}
+			plan.Moves = append(plan.Moves, Move{Slot: k, Soul: sl, To: name, From: from, Strips: from != "" && !planned(from)})
+
+			// The soul it replaces goes back to the inventory.
+			delete(wearers, slotID{k, ids[k]})
+			if from != "" {
+				fromIDs := worn[strings.ToLower(from)]
+				fromIDs[k] = 0
+				worn[strings.ToLower(from)] = fromIDs
+			}
+			if sl.ID != 0 {
+				ids[k] = sl.ID
+				worn[strings.ToLower(name)] = ids
+				wearers[slotID{k, sl.ID}] = name
+			}
+		}
+	}
+	return plan
+}
@@ -0,0 +1,45 @@
+package onmyoji
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwaps(t *testing.T) {
+	db := randomDb(1, 4)
+	db.AssignIDs()
+	set := func(i ...int) SoulSet {
+		var souls [6]Soul
+		for k, slot := range db.slots() {
+			souls[k] = (*slot)[i[k]]
+		}
+		return NewSoulSet(souls)
+	}
+	ids := func(s SoulSet) [6]int { return s.IDs() }
+	db.Slot6[3].Locked = true
+	db.Loadouts = map[string][6]int{
+		"Ibaraki Doji": ids(set(0, 0, 0, 0, 0, 0)),
+		"Kamikui":      {db.Slot1[1].ID},
+		"Seimei":       ids(set(2, 2, 2, 2, 2, 3)),
+	}
+
+	// Ibaraki keeps most souls but swaps slot 2, so Kamikui then equips Ibaraki's old slot 2 from
+	// the inventory. Kamikui also takes slot 1 from Seimei and wants Seimei's locked slot 6.
+	plan := db.Swaps([]string{"Ibaraki Doji", "Kamikui"}, []SoulSet{set(0, 1, 0, 0, 0, 0), set(2, 0, 1, 1, 1, 3)})
+	assert.Equal(t, []Move{
+		{Slot: 1, Soul: db.Slot2[1], To: "Ibaraki Doji"},
+		{Slot: 0, Soul: db.Slot1[2], To: "Kamikui", From: "Seimei", Strips: true},
+		{Slot: 1, Soul: db.Slot2[0], To: "Kamikui"},
+		{Slot: 2, Soul: db.Slot3[1], To: "Kamikui"},
+		{Slot: 3, Soul: db.Slot4[1], To: "Kamikui"},
+		{Slot: 4, Soul: db.Slot5[1], To: "Kamikui"},
+	}, plan.Moves)
+	assert.Equal(t, []Conflict{{Slot: 5, Soul: db.Slot6[3], Wanted: []string{"Kamikui"}, LockedOn: "Seimei"}}, plan.Conflicts)
+	assert.Contains(t, plan.Moves[1].String(), "leaving Seimei without a slot 1 soul")
+
+	// Two shikigami can't share a soul.
+	plan = db.Swaps([]string{"Ibaraki Doji", "Kamikui"}, []SoulSet{set(0, 0, 0, 0, 0, 0), set(1, 1, 1, 1, 1, 0)})
+	assert.Len(t, plan.Moves, 4)
+	assert.Equal(t, []Conflict{{Slot: 5, Soul: db.Slot6[0], Wanted: []string{"Ibaraki Doji", "Kamikui"}}}, plan.Conflicts)
+}
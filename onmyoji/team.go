@@ -0,0 +1,357 @@
+package onmyoji
+
+import (
+	"context"
+	"fmt"
+)
+
+// Limits on how many soul sets are compared for each member when planning a team. Finding more
+// sets costs about as much as searching again, so the number grows quickly.
+const (
+	minTeamCandidates = 128
+	maxTeamCandidates = 8192
+)
+
+// Member describes a shikigami to find souls for as part of a team.
+type Member struct {
+	Query
+	Name string
+	// Weight scales the member's optimized value in the team score.
+	Weight float64
+	// FasterThan lists members of the same team that this member must outpace.
+	FasterThan []SpeedGap
+}
+
+// SpeedGap requires a member's speed to be at least Min more than the speed of another member.
+type SpeedGap struct {
+	// Member is the index of the slower member in the team.
+	Member int
+	Min    int
+}
+
+// inOrder returns whether member i with result a and member j with result b keep the speed order
+// required between them.
+func inOrder(members []Member, i int, a Result, j int, b Result) bool {
+	for _, gap := range members[i].FasterThan {
+		if gap.Member == j && a.Spd-b.Spd < gap.Min {
+			return false
+		}
+	}
+	for _, gap := range members[j].FasterThan {
+		if gap.Member == i && b.Spd-a.Spd < gap.Min {
+			return false
+		}
+	}
+	return true
+}
+
+// Constrain returns the query for member i that also requires it to keep its speed order with the
+// members that already have a result in planned. Members without a soul set yet are ignored.
+func Constrain(members []Member, i int, planned []Result) Query {
+	q := members[i].Query
+	q.Constraints = append([]Constraint(nil), q.Constraints...)
+	for _, gap := range members[i].FasterThan {
+		if other := planned[gap.Member]; !other.Souls.Empty() {
+			q.Constraints = append(q.Constraints, Constraint{Attribute: "spd", Min: other.Spd + gap.Min})
+		}
+	}
+	for j, m := range members {
+		for _, gap := range m.FasterThan {
+			if other := planned[j]; gap.Member == i && !other.Souls.Empty() {
+				max := other.Spd - gap.Min
+				if max <= 0 {
+					// No speed is low enough, but a Max of zero would leave the range open.
+					max = -1
+				}
+				q.Constraints = append(q.Constraints, Constraint{Attribute: "spd", Max: max})
+			}
+		}
+	}
+	return q
+}
+
+func (m Member) score(r Result) float64 {
+	return m.Weight * m.Optimize.Value(r)
+}
+
+// TeamPlan assigns a soul set to each member of a team.
+type TeamPlan struct {
+	// Results holds the soul set for each member, in the same order as the members.
+	Results []Result
+	// Score is the sum of each member's optimized value, scaled by its weight.
+	Score float64
+	// Optimal is false if the plan might not be the best, because too many sets had to be compared,
+	// the search was stopped early or a member's Strategy might miss its best sets.
+	Optimal bool
+	// Stats holds, for each member, the statistics of the searches for its sets. When a member was
+	// searched more than once, the counts are summed over the searches.
+	Stats []Stats
+}
+
+// Clone returns a copy of the database whose souls can be removed without changing db.
+func (db *SoulDb) Clone() SoulDb {
+	cp := func(s []Soul) []Soul { return append([]Soul(nil), s...) }
+	return SoulDb{cp(db.Slot1), cp(db.Slot2), cp(db.Slot3), cp(db.Slot4), cp(db.Slot5), cp(db.Slot6), db.Loadouts, db.current}
+}
+
+// validateMembers returns an error naming the first member whose query isn't valid, or whose
+// speed order names a member outside the team, itself, or a member that must in turn be faster
+// than it.
+func validateMembers(members []Member) error {
+	for i, m := range members {
+		if err := m.Query.Validate(); err != nil {
+			return fmt.Errorf("%v: %v", m.Name, err)
+		}
+		for _, gap := range m.FasterThan {
+			if gap.Member < 0 || gap.Member >= len(members) || gap.Member == i {
+				return fmt.Errorf("%v: must be faster than another member of the team, not member %v", m.Name, gap.Member)
+			}
+		}
+	}
+
+	// Look for cycles in the speed order, visiting each member once.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(members))
+	var visit func(i int) error
+	visit = func(i int) error {
+		state[i] = visiting
+		for _, gap := range members[i].FasterThan {
+			switch state[gap.Member] {
+			case visiting:
+				return fmt.Errorf("%v can't be faster than %v, which must already be faster than it", members[i].Name, members[gap.Member].Name)
+			case unvisited:
+				if err := visit(gap.Member); err != nil {
+					return err
+				}
+			}
+		}
+		state[i] = visited
+		return nil
+	}
+	for i := range members {
+		if state[i] == unvisited {
+			if err := visit(i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GreedyTeam finds the best soul set for each member in turn, removing its souls before finding
+// souls for the next member. Each member keeps its speed order with the members before it, and
+// only uses souls locked to other shikigami if it wears them.
+func (db *SoulDb) GreedyTeam(members []Member) (TeamPlan, error) {
+	return db.GreedyTeamContext(context.Background(), members)
+}
+
+// GreedyTeamContext works like GreedyTeam, but stops searching when ctx is done. It then returns the
+// sets found so far, leaving later members without a set, along with the context's error.
+func (db *SoulDb) GreedyTeamContext(ctx context.Context, members []Member) (TeamPlan, error) {
+	if err := validateMembers(members); err != nil {
+		return TeamPlan{}, err
+	}
+	remaining := db.Clone()
+	plan := TeamPlan{Results: make([]Result, len(members)), Stats: make([]Stats, len(members))}
+	for i, m := range members {
+		usable := remaining.For(m.Name)
+		best, stats, err := usable.BestSetsContext(ctx, 1, Constrain(members, i, plan.Results))
+		plan.Stats[i] = stats
+		if len(best) > 0 {
+			plan.Results[i] = best[0]
+			plan.Score += m.score(best[0])
+			if err := remaining.Remove(best[0].Souls); err != nil {
+				return TeamPlan{}, err
+			}
+		}
+		if err != nil {
+			return plan, err
+		}
+		if len(best) == 0 {
+			return TeamPlan{}, fmt.Errorf("unable to find souls for %v that satisfy constraints", m.Name)
+		}
+	}
+	return plan, nil
+}
+
+// BestTeam finds a soul set for every member that maximizes the team score without giving the
+// same soul to two members or a soul locked to another shikigami, and that keeps the required
+// speed order. It compares the best few sets of each member, and finds more sets for a member until
+// no set outside those already compared could improve the team score.
+func (db *SoulDb) BestTeam(members []Member) (TeamPlan, error) {
+	return db.BestTeamContext(context.Background(), members)
+}
+
+// BestTeamContext works like BestTeam, but stops searching when ctx is done. It then returns the
+// best plan from the sets found so far, if there is one, along with the context's error.
+func (db *SoulDb) BestTeamContext(ctx context.Context, members []Member) (TeamPlan, error) {
+	if err := validateMembers(members); err != nil {
+		return TeamPlan{}, err
+	}
+	for _, m := range members {
+		if m.Weight < 0 {
+			return TeamPlan{}, fmt.Errorf("weight for %v must not be negative", m.Name)
+		}
+	}
+
+	stats := make([]Stats, len(members))
+	// stopped returns the plan from the sets compared before ctx was done.
+	stopped := func(plan TeamPlan, found bool, err error) (TeamPlan, error) {
+		if !found {
+			return TeamPlan{}, err
+		}
+		plan.Optimal = false
+		plan.Stats = stats
+		return plan, err
+	}
+
+	n := make([]int, len(members))
+	candidates := make([][]Result, len(members))
+	for i, m := range members {
+		n[i] = minTeamCandidates
+		var err error
+		usable := db.For(m.Name)
+		if candidates[i], stats[i], err = usable.BestSetsContext(ctx, n[i], m.Query); err != nil {
+			return TeamPlan{}, err
+		}
+		if len(candidates[i]) == 0 {
+			return TeamPlan{}, fmt.Errorf("unable to find souls for %v that satisfy constraints", m.Name)
+		}
+	}
+	for {
+		plan, found := db.assign(ctx, members, candidates, -1)
+		if err := ctx.Err(); err != nil {
+			return stopped(plan, found, err)
+		}
+
+		// A set that wasn't compared is no better than the last set compared for that member. If
+		// that set plus the best plan for the rest of the team could beat the plan, compare more
+		// of that member's sets.
+		more := false
+		for i, m := range members {
+			if len(candidates[i]) < n[i] {
+				// Every set for this member has been compared.
+				continue
+			}
+			bound := m.score(candidates[i][len(candidates[i])-1])
+			if found {
+				othersPlan, _ := db.assign(ctx, members, candidates, i)
+				bound += othersPlan.Score
+			}
+			if !found || bound > plan.Score {
+				if n[i] >= maxTeamCandidates {
+					plan.Optimal = false
+					continue
+				}
+				usable := db.For(m.Name)
+				sets, searched, err := usable.BestSetsContext(ctx, n[i]*4, m.Query)
+				stats[i].then(searched)
+				if err != nil {
+					// The sets found so far might be fewer than those already compared.
+					return stopped(plan, found, err)
+				}
+				n[i] *= 4
+				candidates[i] = sets
+				more = true
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			// Bounds from an interrupted assign can't be trusted.
+			return stopped(plan, found, err)
+		}
+		if !more {
+			if !found {
+				return TeamPlan{}, fmt.Errorf("unable to find souls for every team member without sharing souls while keeping the speed order")
+			}
+			for _, m := range members {
+				// The sets compared might not be the member's best.
+				plan.Optimal = plan.Optimal && !m.Strategy.heuristic()
+			}
+			plan.Stats = stats
+			return plan, nil
+		}
+	}
+}
+
+// assign picks one candidate set for each member that maximizes the team score, without using
+// more copies of a soul than the database holds or breaking the speed order. The member at index
+// skip, if any, is left without a set. It stops early when ctx is done, returning the best plan
+// found so far.
+func (db *SoulDb) assign(ctx context.Context, members []Member, candidates [][]Result, skip int) (TeamPlan, bool) {
+	type slotSoul struct {
+		slot int
+		soul Soul
+	}
+	available := make(map[slotSoul]int)
+	for slot, souls := range [6][]Soul{db.Slot1, db.Slot2, db.Slot3, db.Slot4, db.Slot5, db.Slot6} {
+		for _, sl := range souls {
+			available[slotSoul{slot, sl}]++
+		}
+	}
+
+	// rest[i] is the best score that members i onwards could add to a plan.
+	rest := make([]float64, len(members)+1)
+	for i := len(members) - 1; i >= 0; i-- {
+		rest[i] = rest[i+1]
+		if i != skip {
+			rest[i] += members[i].score(candidates[i][0])
+		}
+	}
+
+	best := TeamPlan{Optimal: true}
+	found := false
+	chosen := make([]Result, len(members))
+	var visit func(i int, score float64)
+	visit = func(i int, score float64) {
+		if ctx.Err() != nil {
+			return
+		}
+		if i == len(members) {
+			if !found || score > best.Score {
+				best.Results = append([]Result(nil), chosen...)
+				best.Score = score
+				found = true
+			}
+			return
+		}
+		if i == skip {
+			chosen[i] = Result{}
+			visit(i+1, score)
+			return
+		}
+
+	next:
+		for _, r := range candidates[i] {
+			s := score + members[i].score(r)
+			if found && s+rest[i+1] <= best.Score {
+				// Candidates are ordered best first, so the rest can't do better either.
+				return
+			}
+			for j := 0; j < i; j++ {
+				if j != skip && !inOrder(members, i, r, j, chosen[j]) {
+					continue next
+				}
+			}
+
+			ok := true
+			for slot, sl := range r.Souls.souls {
+				key := slotSoul{slot, sl}
+				available[key]--
+				ok = ok && available[key] >= 0
+			}
+			if ok {
+				chosen[i] = r
+				visit(i+1, s)
+			}
+			for slot, sl := range r.Souls.souls {
+				available[slotSoul{slot, sl}]++
+			}
+		}
+	}
+	visit(0, 0)
+	return best, found
+}
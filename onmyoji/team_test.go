@@ -0,0 +1,108 @@
+package onmyoji
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBestTeamMatchesExhaustive(t *testing.T) {
+	onikiri, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	ubume, err := GetShikigami("Ubume")
+	assert.NoError(t, err)
+
+	any := func(Result) bool { return true }
+
+	for seed := int64(1); seed <= 3; seed++ {
+		for _, gaps := range [][]SpeedGap{nil, {{Member: 0, Min: 3}}} {
+			db := randomDb(seed, 4)
+			members := []Member{
+				{Name: "Onikiri", Query: Query{Optimize: Damage, Evaluator: Evaluator{Shikigami: onikiri}}, Weight: 1},
+				{Name: "Ubume", Query: Query{Optimize: HP, Evaluator: Evaluator{Shikigami: ubume}}, Weight: 2, FasterThan: gaps},
+			}
+
+			first := exhaustiveResults(db, Damage, members[0].Evaluator, withPairs, any)
+			second := exhaustiveResults(db, HP, members[1].Evaluator, withPairs, any)
+			var expected float64
+			for _, a := range first {
+				for _, b := range second {
+					shared := false
+					for k := range a.Souls.souls {
+						shared = shared || a.Souls.souls[k] == b.Souls.souls[k]
+					}
+					if score := members[0].score(a) + members[1].score(b); !shared && inOrder(members, 0, a, 1, b) && score > expected {
+						expected = score
+					}
+				}
+			}
+
+			plan, err := db.BestTeam(members)
+			assert.NoError(t, err)
+			assert.True(t, plan.Optimal)
+			assert.Equal(t, expected, plan.Score, "seed %v, %v", seed, gaps)
+			assert.Len(t, plan.Stats, len(members))
+			for _, stats := range plan.Stats {
+				assert.Equal(t, 1.0, stats.Covered)
+				assert.True(t, stats.Evaluated > 0)
+			}
+			for _, gap := range gaps {
+				assert.True(t, plan.Results[1].Spd-plan.Results[0].Spd >= gap.Min, "seed %v", seed)
+			}
+
+			// Planning one member at a time can leave no set that keeps the speed order.
+			greedy, err := db.GreedyTeam(members)
+			if gaps == nil {
+				assert.NoError(t, err)
+			}
+			if err == nil {
+				assert.True(t, greedy.Score <= plan.Score)
+				for _, gap := range gaps {
+					assert.True(t, greedy.Results[1].Spd-greedy.Results[0].Spd >= gap.Min, "seed %v", seed)
+				}
+			}
+		}
+	}
+}
+
+func TestBestTeamRejectsSpeedCycles(t *testing.T) {
+	onikiri, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	q := Query{Optimize: Damage, Evaluator: Evaluator{Shikigami: onikiri}}
+	db := randomDb(1, 9)
+
+	for _, gaps := range [][][]SpeedGap{
+		{{{Member: 1, Min: 1}}, {{Member: 0, Min: 1}}, nil},
+		{{{Member: 1, Min: 0}}, {{Member: 2, Min: 0}}, {{Member: 0, Min: 0}}},
+		{{{Member: 0, Min: 1}}, nil, nil},
+		{{{Member: 3, Min: 1}}, nil, nil},
+	} {
+		members := []Member{
+			{Name: "A", Query: q, Weight: 1, FasterThan: gaps[0]},
+			{Name: "B", Query: q, Weight: 1, FasterThan: gaps[1]},
+			{Name: "C", Query: q, Weight: 1, FasterThan: gaps[2]},
+		}
+		_, err := db.BestTeam(members)
+		assert.Error(t, err, "%v", gaps)
+		_, err = db.GreedyTeam(members)
+		assert.Error(t, err, "%v", gaps)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.BestTeamContext(ctx, []Member{{Name: "A", Query: q, Weight: 1}, {Name: "B", Query: q, Weight: 1}})
+	assert.Equal(t, context.Canceled, err)
+
+	// No sets can keep this speed order, so comparing sets only stops with the context.
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err = db.BestTeamContext(ctx, []Member{
+		{Name: "A", Query: q, Weight: 1}, {Name: "B", Query: q, Weight: 1},
+		{Name: "C", Query: q, Weight: 1, FasterThan: []SpeedGap{{Member: 1, Min: 1000}}},
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < 2*time.Second, "took %v", time.Since(start))
+}
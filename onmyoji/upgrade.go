@@ -0,0 +1,116 @@
+package onmyoji
+
+import (
+	"context"
+	"math"
+	"sort"
+)
+
+// Upgrade is how much enhancing a soul to +15 is expected to improve a team member's best set.
+type Upgrade struct {
+	// Slot is the slot of the soul, from 1 to 6, and Soul is the soul as it is now.
+	Slot int
+	Soul Soul
+	// Member is the index of the member whose best set improves the most, or -1 if enhancing the
+	// soul improves no member's best set.
+	Member int
+	// Before is the member's best set with the souls as they are, and After its best set once the
+	// soul is enhanced.
+	Before, After Result
+	// Gain is how much the member's optimized value improves, and Percent is the gain as a
+	// percentage of its value before, or zero if that value was zero.
+	Gain, Percent float64
+}
+
+// AdviseUpgrades ranks the unfinished souls in the database by how much enhancing each of them to
+// +15 is expected to improve the best set of any of the members. Each member is planned on its
+// own, with every soul it can use, rather than sharing souls with the rest of the team. A soul is
+// expected to reach its Projected stats, and souls are ranked by the percentage gain of the member
+// they help most, so members optimizing different things can be compared. Gains without a
+// percentage, because the member's value was zero, and ties are ranked by gain. Souls that help
+// nobody come last. Members without an acceptable set are left out.
+func (db *SoulDb) AdviseUpgrades(members []Member) []Upgrade {
+	upgrades, _ := db.AdviseUpgradesContext(context.Background(), members)
+	return upgrades
+}
+
+// AdviseUpgradesContext works like AdviseUpgrades, but stops when ctx is done. It then returns the
+// souls ranked so far along with the context's error. If a member's query isn't valid, it returns
+// the error without searching.
+func (db *SoulDb) AdviseUpgradesContext(ctx context.Context, members []Member) ([]Upgrade, error) {
+	if err := validateMembers(members); err != nil {
+		return nil, err
+	}
+	usable := make([]SoulDb, len(members))
+	before := make([]Result, len(members))
+	for i, m := range members {
+		usable[i] = db.For(m.Name)
+		results, _, err := usable[i].BestSetsContext(ctx, 1, m.Query)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) > 0 {
+			before[i] = results[0]
+		}
+	}
+
+	var upgrades []Upgrade
+	var err error
+	for k, slot := range db.slots() {
+		for _, sl := range *slot {
+			if !sl.Unfinished() {
+				continue
+			}
+			up := Upgrade{Slot: k + 1, Soul: sl, Member: -1}
+			for i, m := range members {
+				if before[i].Souls.Empty() || findID(*usable[i].slots()[k], sl.ID) < 0 {
+					continue
+				}
+				// Only sets with the enhanced soul can beat the member's best set, so search those.
+				with := usable[i]
+				*with.slots()[k] = []Soul{sl.Projected()}
+				var results []Result
+				if results, _, err = with.BestSetsContext(ctx, 1, m.Query); err != nil {
+					break
+				}
+				if len(results) == 0 {
+					continue
+				}
+				value := m.Optimize.Value(before[i])
+				gain := m.Optimize.Value(results[0]) - value
+				var percent float64
+				if value != 0 {
+					percent = 100 * gain / math.Abs(value)
+				}
+				if gain > 0 && (up.Member < 0 || ranksAhead(percent, gain, up.Percent, up.Gain)) {
+					up.Member, up.Before, up.After, up.Gain, up.Percent = i, before[i], results[0], gain, percent
+				}
+			}
+			if err != nil {
+				break
+			}
+			upgrades = append(upgrades, up)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	sort.SliceStable(upgrades, func(i, j int) bool {
+		a, b := upgrades[i], upgrades[j]
+		if (a.Member < 0) != (b.Member < 0) {
+			return b.Member < 0
+		}
+		return ranksAhead(a.Percent, a.Gain, b.Percent, b.Gain)
+	})
+	return upgrades, err
+}
+
+// ranksAhead returns whether a gain of percent p and amount g ranks ahead of a gain of percent q and
+// amount h.
+func ranksAhead(p, g, q, h float64) bool {
+	if p != q {
+		return p > q
+	}
+	return g > h
+}
@@ -0,0 +1,68 @@
+package onmyoji
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdviseUpgrades(t *testing.T) {
+	onikiri, err := GetShikigami("Onikiri")
+	assert.NoError(t, err)
+	members := []Member{
+		{Name: "Onikiri", Query: Query{Optimize: Damage, Evaluator: Evaluator{Shikigami: onikiri, Options: DamageOptions{Orbs: 5}}}},
+		{Name: "Tank", Query: Query{Optimize: HP, Evaluator: Evaluator{Shikigami: onikiri}}},
+	}
+
+	db := randomDb(2, 3)
+	db.AssignIDs()
+	db.Slot6 = append(db.Slot6,
+		Soul{ID: 100, Type: "Seductress", Stars: 6, CritDmg: 14, Crit: 15, AtkBonus: 15, Main: Stat{"critdmg", 14}},
+		Soul{ID: 101, Type: "Namazu", Stars: 6, Level: 12, HPBonus: 60, HP: 900, Main: Stat{"hpbonus", 46}},
+		Soul{ID: 102, Type: "Namazu", Stars: 1, Level: 14, Def: 10, Main: Stat{"defbonus", 0}},
+	)
+
+	upgrades := db.AdviseUpgrades(members)
+	assert.Len(t, upgrades, 3)
+	for i, up := range upgrades {
+		if i > 0 && up.Member >= 0 {
+			assert.True(t, up.Percent <= upgrades[i-1].Percent, "not ranked: %v", upgrades)
+		}
+		if up.Member < 0 {
+			continue
+		}
+
+		// The gain matches searching the whole database with the soul enhanced.
+		m := members[up.Member]
+		enhanced := db.Clone()
+		for i, sl := range enhanced.Slot6 {
+			if sl.ID == up.Soul.ID {
+				enhanced.Slot6[i] = sl.Projected()
+			}
+		}
+		best := enhanced.BestSet(m.Query)
+		assert.Equal(t, db.BestSet(m.Query).Damage, up.Before.Damage)
+		assert.Equal(t, m.Optimize.Value(best), m.Optimize.Value(up.After), "soul %v", up.Soul)
+		assert.Equal(t, up.Soul.ID, up.After.Souls.Souls()[5].ID)
+		assert.True(t, up.Gain > 0)
+	}
+	assert.Equal(t, 102, upgrades[2].Soul.ID)
+	assert.Equal(t, -1, upgrades[2].Member)
+	assert.Equal(t, 6, upgrades[0].Slot)
+	assert.NotEqual(t, -1, upgrades[1].Member)
+}
+
+func TestAdviseUpgradesFromZero(t *testing.T) {
+	// None of the souls have effect hit, so the gain has no percentage but still counts.
+	members := []Member{{Name: "Debuffer", Query: Query{Optimize: Optimizer("effecthit")}}}
+	db := randomDb(2, 3)
+	db.AssignIDs()
+	db.Slot4 = append(db.Slot4, Soul{ID: 100, Type: "Shadow", Stars: 6, Main: Stat{"effecthit", 0}})
+
+	upgrades := db.AdviseUpgrades(members)
+	assert.Len(t, upgrades, 1)
+	assert.Equal(t, 0, upgrades[0].Member)
+	assert.Equal(t, 0.0, upgrades[0].Percent)
+	assert.True(t, upgrades[0].Gain > 0)
+	assert.Equal(t, 0.0, members[0].Optimize.Value(upgrades[0].Before))
+}
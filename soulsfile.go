@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/MikaelSmith/onmyoji-soul-planner/onmyoji"
+	"gopkg.in/yaml.v3"
+)
+
+// slotKeys are the keys of each slot in a souls file.
+var slotKeys = [6]string{"slot1", "slot2", "slot3", "slot4", "slot5", "slot6"}
+
+// readSouls reads a souls file. Souls without an ID are given one, and the IDs are written back to
+// the file so they stay the same in later runs. It returns how many souls were given IDs.
+func readSouls(path string) (onmyoji.SoulDb, int, error) {
+	var db onmyoji.SoulDb
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return db, 0, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(source, &doc); err != nil || doc.Kind == 0 {
+		// An empty file has no souls.
+		return db, 0, err
+	}
+	if err := doc.Decode(&db); err != nil {
+		return db, 0, err
+	}
+	if err := db.CheckIDs(); err != nil {
+		return db, 0, err
+	}
+	if err := db.CheckMainStats(); err != nil {
+		return db, 0, err
+	}
+	if err := db.CheckLevels(); err != nil {
+		return db, 0, err
+	}
+	assigned := db.AssignIDs()
+	if err := db.CheckLoadouts(); err != nil {
+		return db, 0, err
+	}
+	if assigned == 0 {
+		return db, 0, nil
+	}
+
+	// Add the IDs to the file's text rather than writing the database, to keep its comments and
+	// layout.
+	out, err := addSoulIDs(source, &doc, db)
+	if err != nil {
+		return db, 0, err
+	}
+	return db, assigned, ioutil.WriteFile(path, out, 0644)
+}
+
+// addSoulIDs adds an id to each soul in a souls file that doesn't have one, using its ID in db.
+func addSoulIDs(source []byte, doc *yaml.Node, db onmyoji.SoulDb) ([]byte, error) {
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected a mapping of slots to souls")
+	}
+	root := doc.Content[0]
+	slots := [6][]onmyoji.Soul{db.Slot1, db.Slot2, db.Slot3, db.Slot4, db.Slot5, db.Slot6}
+	var inserts []insert
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		for k, key := range slotKeys {
+			if root.Content[i].Value != key {
+				continue
+			}
+			souls := root.Content[i+1].Content
+			if len(souls) != len(slots[k]) {
+				return nil, fmt.Errorf("%v has %v souls, but %v were read", key, len(souls), len(slots[k]))
+			}
+			for j, soul := range souls {
+				if ins, ok := idInsert(soul, slots[k][j].ID); ok {
+					inserts = append(inserts, ins)
+				}
+			}
+		}
+	}
+
+	// Find where each insert goes, then add them from last to first so the offsets stay valid.
+	lines := lineOffsets(source)
+	out := append([]byte{}, source...)
+	for i := len(inserts) - 1; i >= 0; i-- {
+		ins := inserts[i]
+		if ins.line > len(lines) {
+			return nil, fmt.Errorf("line %v is past the end of the file", ins.line)
+		}
+		at := lines[ins.line-1] + ins.column - 1
+		out = append(out[:at], append([]byte(ins.text), out[at:]...)...)
+	}
+	return out, nil
+}
+
+// insert is text to add before a line and column of a file, both starting from 1.
+type insert struct {
+	line, column int
+	text         string
+}
+
+// idInsert returns the text that adds an id before a soul's first field, or false if the soul
+// already has an id.
+func idInsert(soul *yaml.Node, id int) (insert, bool) {
+	if soul.Kind != yaml.MappingNode || len(soul.Content) == 0 {
+		return insert{}, false
+	}
+	for i := 0; i < len(soul.Content); i += 2 {
+		if soul.Content[i].Value == "id" {
+			return insert{}, false
+		}
+	}
+	first := soul.Content[0]
+	text := "id: " + strconv.Itoa(id)
+	if soul.Style&yaml.FlowStyle != 0 {
+		text += ", "
+	} else {
+		text += "\n" + strings.Repeat(" ", first.Column-1)
+	}
+	return insert{line: first.Line, column: first.Column, text: text}, true
+}
+
+// lineOffsets returns the offset of the start of each line in source.
+func lineOffsets(source []byte) []int {
+	offsets := []int{0}
+	for i, b := range source {
+		if b == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/MikaelSmith/onmyoji-soul-planner/onmyoji"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestTeamMembersSpeedGaps(t *testing.T) {
+	var team []member
+	assert.NoError(t, yaml.Unmarshal([]byte(`
+- name: Onikiri
+  constraints:
+    spd:
+      faster_than: Ubume
+      by: 0
+- name: Ubume
+  constraints:
+    spd:
+      slower_than: Ibaraki Doji
+- name: Ibaraki Doji
+  constraints:
+    spd:
+      low: 150
+`), &team))
+
+	members := teamMembers(team)
+	// A gap of 0 allows the same speed, and leaving it out requires being at least 1 faster.
+	assert.Equal(t, []onmyoji.SpeedGap{{Member: 1, Min: 0}}, members[0].FasterThan)
+	assert.Equal(t, []onmyoji.SpeedGap{{Member: 1, Min: 1}}, members[2].FasterThan)
+
+	// A speed order alone doesn't add an empty speed constraint.
+	assert.Empty(t, members[0].Constraints)
+	assert.Nil(t, members[0].TieBreak)
+	assert.Equal(t, []onmyoji.Constraint{{Attribute: "spd", Min: 150}}, members[2].Constraints)
+}